@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbctx"
+)
+
+func lbx_make_cmd_deps() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_deps,
+		UsageLine: "deps [options] <project-name> [<project-version>]",
+		Short:     "print the dependency graph of a project",
+		Long: `
+deps prints the projects and data packages used by a project, as described
+by the manifest.xml files of the installed projects.
+
+A dependency used with different versions is a version conflict: the graph
+is printed with the version closest to the project, and deps exits with an
+error.
+
+ex:
+ $ lbx deps DaVinci v34r1
+ $ lbx deps -o=list DaVinci
+ $ lbx deps -o=dot DaVinci v34r1 | dot -Tpng > davinci.png
+`,
+		Flag: *flag.NewFlagSet("lbx-deps", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	add_platform(cmd)
+	cmd.Flag.String("o", "tree", "output format (tree, list or dot)")
+	return cmd
+}
+
+func lbx_run_cmd_deps(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	proj := ""
+	vers := "latest"
+
+	switch len(args) {
+	case 1:
+		proj = args[0]
+	case 2:
+		proj = args[0]
+		vers = args[1]
+	default:
+		g_ctx.Errorf("lbx-deps: needs 1 or 2 args (project [version]). got=%d\n", len(args))
+		return fmt.Errorf("lbx-deps: invalid number of arguments")
+	}
+
+	proj = lbctx.FixProjectCase(proj)
+	platform := cmd.Flag.Lookup("c").Value.Get().(string)
+
+	vers, err = g_ctx.ExpandVersionAlias(proj, vers)
+	if err != nil {
+		g_ctx.Errorf("lbx-deps: problem resolving version: %v\n", err)
+		return err
+	}
+
+	graph, err := g_ctx.Dependencies(proj, vers, platform)
+	if err != nil {
+		g_ctx.Errorf("lbx-deps: problem building dependency graph: %v\n", err)
+		return err
+	}
+
+	switch format := cmd.Flag.Lookup("o").Value.Get().(string); format {
+	case "tree":
+		err = graph.WriteTree(os.Stdout)
+	case "list":
+		err = graph.WriteList(os.Stdout)
+	case "dot":
+		err = graph.WriteDOT(os.Stdout)
+	default:
+		return fmt.Errorf("lbx-deps: unknown output format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, c := range graph.Conflicts {
+		g_ctx.Errorf("version conflict: %v\n", c)
+	}
+	if len(graph.Conflicts) > 0 {
+		err = fmt.Errorf("lbx-deps: %d version conflict(s)", len(graph.Conflicts))
+	}
+
+	return err
+}
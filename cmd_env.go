@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbctx"
+	"github.com/lhcb-org/lbx/lbenv"
+)
+
+func lbx_make_cmd_env() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_env,
+		UsageLine: "env [options] [<project-name> [<project-version>]]",
+		Short:     "print the runtime environment of a project",
+		Long: `
+env prints the runtime environment 'lbx run' would use, as a script to be
+evaluated by a shell or as a JSON document.
+
+By default, the project, version and platform of the current workarea are used.
+
+ex:
+ $ eval $(lbx env)
+ $ eval $(lbx env -diff DaVinci v34r1)
+ $ eval (lbx env -shell=fish)
+ $ lbx env -shell=json Gaudi
+
+With -explain, env prints how the value of a variable was built, step by
+step: the file and line of each action (and the files including it), the
+values rewritten or dropped by the processors, and the resulting value.
+
+ex:
+ $ lbx env -explain=PYTHONPATH DaVinci v34r1
+
+The sh, csh and fish scripts (without -diff) also save how to revert the
+changes they make in a file under ~/.lbx/env-undo, named by LBX_ENV_UNDO.
+The same changes are saved in the same file.
+With -undo, env prints a script reverting the last evaluated 'lbx env': the
+overwritten values are restored, the entries added to lists are removed, and
+the new variables are unset.
+
+ex:
+ $ eval $(lbx env Gaudi)
+ $ eval $(lbx env -undo)
+`,
+		Flag: *flag.NewFlagSet("lbx-env", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	add_runtime_projects(cmd)
+	add_platform(cmd)
+	cmd.Flag.String("shell", Getenv("SHELL", "sh"), "type of the output script (sh, bash, zsh, csh, tcsh, fish or json)")
+	cmd.Flag.Bool("diff", false, "only print the variables which differ from the current environment")
+	cmd.Flag.String("explain", "", "print how the value of this variable was built instead of the environment")
+	cmd.Flag.Bool("undo", false, "print a script reverting the last evaluated 'lbx env'")
+	return cmd
+}
+
+func lbx_run_cmd_env(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	shell, err := lbenv.ParseShellType(cmd.Flag.Lookup("shell").Value.Get().(string))
+	if err != nil {
+		g_ctx.Errorf("lbx-env: %v\n", err)
+		return err
+	}
+
+	if cmd.Flag.Lookup("undo").Value.Get().(bool) {
+		if len(args) > 0 {
+			g_ctx.Errorf("lbx-env: -undo takes no argument. got=%d\n", len(args))
+			return fmt.Errorf("lbx-env: invalid number of arguments")
+		}
+		return lbx_env_undo(shell)
+	}
+
+	proj, vers, err := lbx_env_project(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	env, err := lbx_runtime_env(cmd, proj, vers)
+	if err != nil {
+		return err
+	}
+
+	if name := cmd.Flag.Lookup("explain").Value.Get().(string); name != "" {
+		return lbx_env_explain(env, name)
+	}
+
+	diff := cmd.Flag.Lookup("diff").Value.Get().(bool)
+
+	// only the full scripts are meant to be evaluated by a shell.
+	if shell != lbenv.JSONType && !diff {
+		err = lbx_env_save_undo(env)
+		if err != nil {
+			return err
+		}
+	}
+
+	if diff {
+		err = env.GenScriptDiff(shell, os.Stdout, os.Environ())
+	} else {
+		err = env.GenScript(shell, os.Stdout)
+	}
+	lbx_env_warn_skipped(env)
+	return err
+}
+
+// lbx_env_warn_skipped warns about the variables left out of the generated
+// script, as their name can not be used by a shell.
+func lbx_env_warn_skipped(env *lbenv.Environment) {
+	for _, name := range env.Skipped {
+		g_ctx.Warnf("lbx-env: skipping variable %q (not a valid shell variable name)\n", name)
+	}
+}
+
+// lbx_env_undo_var is the variable holding the actions reverting the last
+// evaluated 'lbx env'.
+const lbx_env_undo_var = "LBX_ENV_UNDO"
+
+// lbx_env_save_undo writes the actions reverting env to the current
+// environment in an XML environment file under ~/.lbx/env-undo, and stores
+// its name in LBX_ENV_UNDO. (the actions may be too large for the value of a
+// variable.)
+func lbx_env_save_undo(env *lbenv.Environment) error {
+	var err error
+
+	// set the variable first, so the inverse restores its previous value
+	// and nested 'lbx env' are reverted one at a time.
+	err = env.Set(lbx_env_undo_var, "pending")
+	if err != nil {
+		return err
+	}
+
+	fname, err := lbx_env_write_undo(env.Inverse(os.Environ()))
+	if err != nil {
+		g_ctx.Warnf("lbx-env: can not save how to undo the environment: %v\n", err)
+		if old, ok := os.LookupEnv(lbx_env_undo_var); ok {
+			return env.Set(lbx_env_undo_var, old)
+		}
+		return env.Unset(lbx_env_undo_var)
+	}
+
+	return env.Set(lbx_env_undo_var, fname)
+}
+
+// lbx_env_write_undo writes the undo actions to a file named after their
+// content, and returns its name. The file is reused by the shells evaluating
+// the same environment (e.g. from a shell rc file), so they do not leave one
+// file each behind.
+func lbx_env_write_undo(actions []lbenv.Action) (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("HOME is not set")
+	}
+
+	var buf bytes.Buffer
+	err := lbenv.Encode(&buf, actions)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(home, ".lbx", "env-undo")
+	fname := filepath.Join(dir, fmt.Sprintf("%x.xml", sha1.Sum(buf.Bytes())))
+	if path_exists(fname) {
+		return fname, nil
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	// write to a temporary file first, so concurrent shells never read a
+	// partial file.
+	f, err := ioutil.TempFile(dir, ".undo-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(f.Name(), fname)
+	if err != nil {
+		return "", err
+	}
+	return fname, nil
+}
+
+// lbx_env_undo prints the script reverting the last evaluated 'lbx env',
+// from the actions saved in the LBX_ENV_UNDO file.
+func lbx_env_undo(shell lbenv.ShellType) error {
+	fname := os.Getenv(lbx_env_undo_var)
+	if fname == "" {
+		g_ctx.Errorf("lbx-env: nothing to undo (%s is not set)\n", lbx_env_undo_var)
+		return fmt.Errorf("lbx-env: nothing to undo")
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		g_ctx.Errorf("lbx-env: can not read how to undo the environment: %v\n", err)
+		return err
+	}
+	defer f.Close()
+
+	actions, err := lbenv.Decode(f)
+	if err != nil {
+		g_ctx.Errorf("lbx-env: invalid %s file: %v\n", lbx_env_undo_var, err)
+		return err
+	}
+
+	// the saved values are restored as they are.
+	env := lbenv.New()
+	env.Processors = nil
+	err = env.Replay(actions)
+	if err != nil {
+		g_ctx.Errorf("lbx-env: error reverting the environment: %v\n", err)
+		return err
+	}
+
+	// only the reverted variables are printed (or unset).
+	reverted := make(map[string]struct{})
+	for _, step := range env.History("") {
+		reverted[step.Var] = struct{}{}
+	}
+	environ := make([]string, 0, len(reverted))
+	for _, kv := range os.Environ() {
+		if _, ok := reverted[strings.SplitN(kv, "=", 2)[0]]; ok {
+			environ = append(environ, kv)
+		}
+	}
+	err = env.GenScriptDiff(shell, os.Stdout, environ)
+	lbx_env_warn_skipped(env)
+	return err
+}
+
+// lbx_env_explain prints the steps which built the value of the variable name.
+func lbx_env_explain(env *lbenv.Environment, name string) error {
+	steps := env.History(name)
+	if len(steps) == 0 {
+		g_ctx.Errorf("lbx-env: no variable %q in the environment\n", name)
+		return fmt.Errorf("lbx-env: no such variable")
+	}
+
+	for i, step := range steps {
+		src := "initial environment"
+		if step.Source != nil {
+			src = step.Source.String()
+		}
+		fmt.Printf("%d. %s\n   %v\n", i+1, src, step.Action)
+		for _, c := range step.Changes {
+			if len(c.Dropped) > 0 {
+				fmt.Printf("   dropped by %s: %s\n", c.Processor, strings.Join(c.Dropped, " "))
+				continue
+			}
+			fmt.Printf("   rewritten by %s: %q => %q\n", c.Processor, c.In, c.Out)
+		}
+		if step.Unset {
+			fmt.Printf("   => (unset)\n")
+		} else {
+			fmt.Printf("   => %q\n", step.Value)
+		}
+	}
+
+	if v := env.Get(name); env.Has(name) && v.Local {
+		fmt.Printf("(%s is local: it is not exported)\n", name)
+	}
+	return nil
+}
+
+// lbx_env_project returns the project and version selected by the optional
+// <project-name> [<project-version>] arguments of cmd, defaulting to the ones
+// of the current workarea.
+// It also sets the platform from the -c flag when no workarea is used.
+func lbx_env_project(cmd *commander.Command, args []string) (string, string, error) {
+	proj := g_ctx.Project
+	vers := g_ctx.Version
+
+	switch len(args) {
+	case 0:
+		if proj == "" {
+			g_ctx.Errorf("lbx-%s: not in a workarea. needs a project name\n", cmd.Name())
+			return "", "", fmt.Errorf("lbx-%s: invalid number of arguments", cmd.Name())
+		}
+	case 1:
+		proj = lbctx.FixProjectCase(args[0])
+		vers = "latest"
+	case 2:
+		proj = lbctx.FixProjectCase(args[0])
+		vers = args[1]
+	default:
+		g_ctx.Errorf("lbx-%s: needs at most 2 args (project+version). got=%d\n", cmd.Name(), len(args))
+		return "", "", fmt.Errorf("lbx-%s: invalid number of arguments", cmd.Name())
+	}
+
+	if len(args) > 0 || g_ctx.Platform == "" {
+		g_ctx.Platform = cmd.Flag.Lookup("c").Value.Get().(string)
+	}
+
+	return proj, vers, nil
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/gonuts/commander"
 	"github.com/gonuts/flag"
-	"github.com/gonuts/gas"
 	"github.com/gonuts/logger"
 	"github.com/gonuts/toml"
 	"github.com/lhcb-org/lbx/lbctx"
@@ -108,6 +107,12 @@ func lbx_run_cmd_init(cmd *commander.Command, args []string) error {
 		g_ctx.ProjectsPath = append(strings.Split(devdirs, string(os.PathListSeparator)), g_ctx.ProjectsPath...)
 	}
 
+	vers, err = g_ctx.ExpandVersionAlias(proj, vers)
+	if err != nil {
+		g_ctx.Errorf("lbx-init: problem resolving version: %v\n", err)
+		return err
+	}
+
 	g_ctx.Infof(">>> project=%q version=%q\n", proj, vers)
 	g_ctx.Infof("local-proj=%q\n", local_proj)
 	g_ctx.Infof("local-vers=%q\n", local_vers)
@@ -127,11 +132,6 @@ func lbx_run_cmd_init(cmd *commander.Command, args []string) error {
 	}
 
 	// create the local dev project
-	templates_dir, err := gas.Abs("github.com/lhcb-org/lbx/templates")
-	if err != nil {
-		g_ctx.Errorf("lbx-init: problem locating templates: %v\n", err)
-		return err
-	}
 	templates := []string{
 		"CMakeLists.txt", "toolchain.cmake", "Makefile",
 		"searchPath.cmake",
@@ -168,7 +168,11 @@ func lbx_run_cmd_init(cmd *commander.Command, args []string) error {
 	}
 
 	for _, tmpl := range templates {
-		fname := filepath.Join(templates_dir, tmpl)
+		fname, err := lbx_template_file(tmpl)
+		if err != nil {
+			g_ctx.Errorf("lbx-init: problem locating templates: %v\n", err)
+			return err
+		}
 		t := template.Must(template.New(tmpl).ParseFiles(fname))
 		oname := filepath.Join(local_projdir, tmpl)
 		dest, err := os.Create(oname)
@@ -11,9 +11,10 @@ func lbx_make_cmd_pkg() *commander.Command {
 		Short:     "add, remove or inspect sub-packages",
 		Subcommands: []*commander.Command{
 			lbx_make_cmd_pkg_add(),
-			// lbx_make_cmd_pkg_create(),
-			// lbx_make_cmd_pkg_ls(),
-			// lbx_make_cmd_pkg_rm(),
+			lbx_make_cmd_pkg_create(),
+			lbx_make_cmd_pkg_ls(),
+			lbx_make_cmd_pkg_rm(),
+			lbx_make_cmd_pkg_update(),
 		},
 		Flag: *flag.NewFlagSet("lbx-pkg", flag.ExitOnError),
 	}
@@ -1,29 +1,88 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/gonuts/commander"
 	"github.com/gonuts/flag"
+	"github.com/lhcb-org/lbx/lbctx"
+	"github.com/lhcb-org/lbx/lbctx/vcs"
 	"github.com/lhcb-org/lbx/lbrelease"
 )
 
 func lbx_make_cmd_pkg_add() *commander.Command {
 	cmd := &commander.Command{
 		Run:       lbx_run_cmd_pkg_add,
-		UsageLine: "co [options] <pkg-uri> [<pkg-version>]",
+		UsageLine: "co [options] <pkg-uri> [<pkg-version>] | <pkg-uri>...",
 		Short:     "add a package to the current workarea",
 		Long: `
-co adds a package to the current workarea.
+co adds packages to the current workarea.
+
+The package is either the name of a package known to the repositories
+database, or a full URI:
+ - git+ssh://host/Repo/Hat/Pkg (sparse checkout of Hat/Pkg from Repo),
+ - svn+ssh://host/path/to/Pkg (checkout of trunk or tags/<pkg-version>),
+ - hg+ssh://host/path/to/Pkg, hg://host/path/to/Pkg (over https),
+   bzr+ssh://host/path/to/Pkg or bzr://host/path/to/Pkg (clone of the default
+   branch or of the <pkg-version> tag),
+ - file:///path/to/repository or a local repository (checked out as a whole),
+ - ./path/to/Pkg, ../path/to/Pkg or /path/to/Pkg: a local directory (copied).
+URIs without a scheme are prefixed with ${SVNROOT} if they do not exist locally.
+
+A single package may be followed by its version (a release version such as
+vXrY or X.Y.Z, or 'head'). Any package may also be given as <pkg>@<version>,
+e.g. for a branch name. The other packages are checked out at their head.
+A list of '<pkg> [<version>]' lines can also be given with -f. Several
+packages are checked out concurrently, by -j workers, and the failures are
+summarized at the end.
+
+The version control commands are killed after -timeout (if not zero), or on
+Ctrl-C, and the temporary directories of the checkouts are removed.
+
+With -for-project, the packages without an explicit version are checked out
+at the version used by an installed release of a project (or of the projects
+it uses).
+
+With -with-deps (resp. -with-users), the packages of the same project which
+the packages (transitively) depend on (resp. which depend on them) are also
+checked out. The dependencies are read from the gaudi_depends_on_subdirs
+declarations and cmt 'use' statements of the installed project: the
+-for-project one, or the one of the current workarea. These packages are
+checked out at the version used by the -for-project release, or at their head.
+
+The repositories database is built-in, and can be extended with the
+[[repository]] entries of /etc/lbx/repositories.toml, ~/.lbx/repositories.toml
+and .lbx/repositories.toml.
 
 ex:
  $ lbx pkg co MyPackage vXrY
+ $ lbx pkg co -for-project=DaVinci/v34r1 Phys/DaVinciKernel
+ $ lbx pkg co -for-project=DaVinci/v34r1 -with-users Phys/DaVinciKernel
+ $ lbx pkg co -j=8 Phys/DaVinciKernel Phys/DaVinciTools@v2r0
+ $ lbx pkg co -f=packages.txt
+ $ lbx pkg co git+ssh://git@github.com/lhcb/Repo/Hat/MyPackage v1r0
+ $ lbx pkg co -name=Hat/MyPackage ../other/Hat/MyPackage
 `,
 		Flag: *flag.NewFlagSet("lbx-pkg-co", flag.ExitOnError),
 	}
 	cmd.Flag.Bool("v", false, "enable verbose output")
 	cmd.Flag.Bool("go", true, "use the go version")
+	add_repositories(cmd)
+	add_platform(cmd)
+	add_timeout(cmd)
+	cmd.Flag.String("for-project", "", "check out the version of the package used by this project (e.g. DaVinci/v34r1)")
+	cmd.Flag.Bool("with-deps", false, "also check out the packages of the project the package depends on")
+	cmd.Flag.Bool("with-users", false, "also check out the packages of the project depending on the package")
+	cmd.Flag.String("f", "", "file listing the packages to check out ('<pkg> [<version>]' lines)")
+	cmd.Flag.Int("j", 4, "number of concurrent checkouts")
+	cmd.Flag.String("name", "", "name of the package in the workarea (full URIs only)")
 	return cmd
 }
 
@@ -47,26 +106,420 @@ func lbx_run_cmd_pkg_add(cmd *commander.Command, args []string) error {
 		return err
 	}
 
-	pkgname := ""
-	pkgvers := ""
+	reqs, err := lbx_pkg_co_requests(cmd, args)
+	if err != nil {
+		return err
+	}
 
-	switch len(args) {
-	case 1:
-		pkgname = args[0]
-		pkgvers = "head"
-	case 2:
-		pkgname = args[0]
-		pkgvers = args[1]
-	default:
+	if len(reqs) > 1 && cmd.Flag.Lookup("name").Value.Get().(string) != "" {
+		g_ctx.Errorf("lbx-pkg-co: -name needs a single package\n")
+		return fmt.Errorf("lbx-pkg-co: invalid arguments")
+	}
+
+	if forproj := cmd.Flag.Lookup("for-project").Value.Get().(string); forproj != "" {
+		for i, req := range reqs {
+			if req.version != "" || lbx_is_pkg_uri(req.name) {
+				continue
+			}
+			reqs[i].version, err = lbx_pkg_version_for_project(cmd, forproj, req.name)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
+	withdeps := cmd.Flag.Lookup("with-deps").Value.Get().(bool)
+	withusers := cmd.Flag.Lookup("with-users").Value.Get().(bool)
+	if withdeps || withusers {
+		reqs, err = lbx_pkg_co_closure(cmd, reqs, withdeps, withusers)
+		if err != nil {
+			return err
+		}
 	}
 
 	gp := &lbrelease.GetPack{
-		ReqPkg:     pkgname,
-		ReqPkgVers: pkgvers,
+		User:     cmd.Flag.Lookup("user").Value.Get().(string),
+		Protocol: cmd.Flag.Lookup("protocol").Value.Get().(string),
+	}
+
+	ctx, cancel := lbx_context(cmd)
+	defer cancel()
+
+	if len(reqs) == 1 {
+		err = lbx_pkg_co_one(ctx, cmd, gp, reqs[0])
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-co: problem checking out %s:\n", reqs[0])
+			lbx_pkg_co_report(cmd, reqs[0], err)
+			return err
+		}
+		g_ctx.Infof("checked out %s\n", reqs[0])
+		return err
+	}
+
+	return lbx_pkg_co_many(ctx, cmd, gp, reqs)
+}
+
+// lbx_pkg_request is a package to check out, at a given version: "head" for
+// its head, or empty if no version was requested (the head as well, unless
+// -for-project gives one).
+type lbx_pkg_request struct {
+	name    string
+	version string
+}
+
+func (req lbx_pkg_request) String() string {
+	if req.version == "" {
+		return "[" + req.name + "]"
+	}
+	return "[" + req.name + "] " + req.version
+}
+
+// lbx_pkg_co_requests returns the packages requested on the command line and
+// in the -f list file.
+// A package may be followed by its version, as long as it is the only
+// package of the command line. Otherwise, the versions are given as
+// <pkg>@<version>, and packages without one are checked out at their head (or
+// at their -for-project version).
+// An explicit head version ("head", "trunk" or "HEAD") is kept as "head", so
+// -for-project does not override it.
+func lbx_pkg_co_requests(cmd *commander.Command, args []string) ([]lbx_pkg_request, error) {
+	var reqs []lbx_pkg_request
+
+	if fname := cmd.Flag.Lookup("f").Value.Get().(string); fname != "" {
+		list, err := lbx_read_pkg_list(fname)
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-co: problem reading [%s]: %v\n", fname, err)
+			return nil, err
+		}
+		reqs = append(reqs, list...)
+	}
+
+	switch {
+	case len(args) == 2 && lbx_is_pkg_version(args[1]):
+		reqs = append(reqs, lbx_pkg_request{args[0], args[1]})
+	default:
+		for _, arg := range args {
+			reqs = append(reqs, lbx_split_pkg_version(arg))
+		}
+	}
+
+	if len(reqs) == 0 {
+		g_ctx.Errorf("lbx-pkg-co: needs at least one package (or a -f list file)\n")
+		return nil, fmt.Errorf("lbx-pkg-co: invalid number of arguments")
+	}
+
+	for i, req := range reqs {
+		switch req.version {
+		case "head", "trunk", "HEAD":
+			reqs[i].version = "head"
+		}
+	}
+	return reqs, nil
+}
+
+// lbx_read_pkg_list reads a list of packages to check out: one package per
+// line, optionally followed by its version. Blank lines and lines starting
+// with '#' are ignored.
+func lbx_read_pkg_list(fname string) ([]lbx_pkg_request, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reqs []lbx_pkg_request
+	scan := bufio.NewScanner(f)
+	for i := 1; scan.Scan(); i++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		toks := strings.Fields(line)
+		switch len(toks) {
+		case 1:
+			reqs = append(reqs, lbx_pkg_request{toks[0], ""})
+		case 2:
+			reqs = append(reqs, lbx_pkg_request{toks[0], toks[1]})
+		default:
+			return nil, fmt.Errorf("%s:%d: expected '<pkg> [<version>]', got %q", fname, i, line)
+		}
+	}
+	return reqs, scan.Err()
+}
+
+// lbx_is_pkg_version returns whether s is a package version rather than the
+// name of a package.
+func lbx_is_pkg_version(s string) bool {
+	switch s {
+	case "head", "trunk":
+		return true
+	}
+	v := lbctx.ParseVersion(s)
+	return v.IsRelease() || v.IsHead()
+}
+
+// lbx_split_pkg_version splits a <pkg>@<version> argument.
+// Only a '@' after the last '/' starts a version, so the user part of URIs
+// (e.g. git+ssh://git@host/Repo/Hat/Pkg) is kept.
+func lbx_split_pkg_version(arg string) lbx_pkg_request {
+	i := strings.LastIndex(arg, "@")
+	if i <= 0 || i < strings.LastIndex(arg, "/") || i == len(arg)-1 {
+		return lbx_pkg_request{arg, ""}
+	}
+	return lbx_pkg_request{arg[:i], arg[i+1:]}
+}
+
+// lbx_pkg_co_one checks out the package req, by name or full URI.
+// The checkout is aborted when ctx is done.
+func lbx_pkg_co_one(ctx context.Context, cmd *commander.Command, gp *lbrelease.GetPack, req lbx_pkg_request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if lbx_is_pkg_uri(req.name) {
+		return lbx_pkg_co_uri(ctx, cmd, req.name, req.version)
+	}
+	return gp.CheckoutContext(ctx, req.name, req.version)
+}
+
+// lbx_pkg_co_many checks out the packages reqs with a pool of -j workers.
+// The progress is reported package by package, and the failures are
+// summarized at the end. The packages not checked out yet when ctx is done
+// are reported as failed.
+func lbx_pkg_co_many(ctx context.Context, cmd *commander.Command, gp *lbrelease.GetPack, reqs []lbx_pkg_request) error {
+	njobs := cmd.Flag.Lookup("j").Value.Get().(int)
+	if njobs < 1 {
+		njobs = 1
+	}
+	if njobs > len(reqs) {
+		njobs = len(reqs)
+	}
+
+	type result struct {
+		req lbx_pkg_request
+		err error
+	}
+
+	jobs := make(chan lbx_pkg_request)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < njobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range jobs {
+				results <- result{req, lbx_pkg_co_one(ctx, cmd, gp, req)}
+			}
+		}()
+	}
+	go func() {
+		for _, req := range reqs {
+			jobs <- req
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	g_ctx.Infof("checking out %d package(s) (%d jobs)...\n", len(reqs), njobs)
+	var failed []result
+	n := 0
+	for res := range results {
+		n++
+		if res.err != nil {
+			g_ctx.Errorf("[%d/%d] %s: FAILED\n", n, len(reqs), res.req)
+			failed = append(failed, res)
+			continue
+		}
+		g_ctx.Infof("[%d/%d] %s: ok\n", n, len(reqs), res.req)
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	g_ctx.Errorf("lbx-pkg-co: %d/%d package(s) could not be checked out:\n", len(failed), len(reqs))
+	for _, res := range failed {
+		lbx_pkg_co_report(cmd, res.req, res.err)
+	}
+	return fmt.Errorf("lbx-pkg-co: %d package(s) could not be checked out", len(failed))
+}
+
+// lbx_pkg_co_report reports the failure err to check out req, with a hint
+// for the classified version control failures, and their output with -v.
+func lbx_pkg_co_report(cmd *commander.Command, req lbx_pkg_request, err error) {
+	g_ctx.Errorf("  %s: %v\n", req, err)
+
+	switch vcs.KindOf(err) {
+	case vcs.ErrAuth:
+		g_ctx.Errorf("    (authentication failure: check your credentials, kerberos token or ssh keys, or use another -protocol)\n")
+	case vcs.ErrNetwork:
+		g_ctx.Errorf("    (network failure: check the connection to the repository and retry)\n")
+	case vcs.ErrNotFound:
+		g_ctx.Errorf("    (not found: check the name and version of the package)\n")
+	case vcs.ErrTimeout:
+		g_ctx.Errorf("    (timeout: the repository may be unreachable or waiting for a password; see -timeout)\n")
+	}
+
+	verbose := cmd.Flag.Lookup("v").Value.Get().(bool)
+	if verr, ok := err.(*vcs.Error); ok && verbose {
+		for _, line := range strings.Split(strings.TrimSpace(string(verr.Output)), "\n") {
+			g_ctx.Errorf("    | %s\n", line)
+		}
+	}
+}
+
+// lbx_pkg_co_closure adds to reqs the packages of their projects they depend
+// on (withdeps) and/or the packages depending on them (withusers).
+// The added packages are at the version used by the -for-project release, or
+// at their head.
+func lbx_pkg_co_closure(cmd *commander.Command, reqs []lbx_pkg_request, withdeps, withusers bool) ([]lbx_pkg_request, error) {
+	var err error
+
+	proj, vers := g_ctx.Project, g_ctx.Version
+	forproj := cmd.Flag.Lookup("for-project").Value.Get().(string)
+	if forproj != "" {
+		proj, vers, err = lbx_parse_for_project(forproj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if proj == "" {
+		g_ctx.Errorf("lbx-pkg-co: not in a workarea. -with-deps and -with-users need -for-project\n")
+		return nil, fmt.Errorf("lbx-pkg-co: no project")
+	}
+	platform := cmd.Flag.Lookup("c").Value.Get().(string)
+
+	out := make([]lbx_pkg_request, 0, len(reqs))
+	seen := make(map[string]bool)
+	projdeps := make(map[string]lbctx.PackageDeps)
+	for _, req := range reqs {
+		if lbx_is_pkg_uri(req.name) {
+			g_ctx.Errorf("lbx-pkg-co: -with-deps and -with-users need package names, not URIs (%s)\n", req.name)
+			return nil, fmt.Errorf("lbx-pkg-co: invalid arguments")
+		}
+
+		projdir, _, err := g_ctx.LocatePackage(proj, vers, platform, req.name)
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-co: %v\n", err)
+			return nil, err
+		}
+
+		deps, ok := projdeps[projdir]
+		if !ok {
+			deps, err = lbctx.ProjectPackageDeps(projdir)
+			if err != nil {
+				g_ctx.Errorf("lbx-pkg-co: problem reading the dependencies of the packages of [%s]: %v\n", projdir, err)
+				return nil, err
+			}
+			projdeps[projdir] = deps
+		}
+		pkgname, ok := deps.Lookup(req.name)
+		if !ok {
+			err = fmt.Errorf("lbx-pkg-co: no package [%s] in [%s]", req.name, projdir)
+			g_ctx.Errorf("%v\n", err)
+			return nil, err
+		}
+
+		if !seen[pkgname] {
+			seen[pkgname] = true
+			out = append(out, lbx_pkg_request{pkgname, req.version})
+		}
+
+		var pkgs []string
+		if withdeps {
+			pkgs = append(pkgs, deps.Closure(pkgname, false)...)
+		}
+		if withusers {
+			pkgs = append(pkgs, deps.Closure(pkgname, true)...)
+		}
+		for _, pkg := range pkgs {
+			if seen[pkg] {
+				continue
+			}
+			seen[pkg] = true
+			version := ""
+			if forproj != "" {
+				version, err = g_ctx.PackageVersion(proj, vers, platform, pkg)
+				if err != nil {
+					g_ctx.Errorf("lbx-pkg-co: %v\n", err)
+					return nil, err
+				}
+			}
+			out = append(out, lbx_pkg_request{pkg, version})
+		}
+	}
+
+	return out, nil
+}
+
+// lbx_parse_for_project returns the project name and (alias-expanded)
+// version of a <project>/<version> release.
+func lbx_parse_for_project(forproj string) (string, string, error) {
+	proj, vers := forproj, "latest"
+	if i := strings.Index(forproj, "/"); i >= 0 {
+		proj, vers = forproj[:i], forproj[i+1:]
+	}
+	proj = lbctx.FixProjectCase(proj)
+
+	vers, err := g_ctx.ExpandVersionAlias(proj, vers)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-co: problem resolving version: %v\n", err)
+		return "", "", err
+	}
+	return proj, vers, nil
+}
+
+// lbx_pkg_version_for_project returns the version of pkg used by the
+// project release forproj (<project>/<version>).
+func lbx_pkg_version_for_project(cmd *commander.Command, forproj, pkg string) (string, error) {
+	proj, vers, err := lbx_parse_for_project(forproj)
+	if err != nil {
+		return "", err
+	}
+
+	platform := cmd.Flag.Lookup("c").Value.Get().(string)
+	pkgvers, err := g_ctx.PackageVersion(proj, vers, platform, pkg)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-co: problem finding the version of [%s] in [%s %s]: %v\n", pkg, proj, vers, err)
+		return "", err
+	}
+	g_ctx.Infof("using [%s] %s from [%s %s]\n", pkg, pkgvers, proj, vers)
+	return pkgvers, nil
+}
+
+// lbx_is_pkg_uri returns whether pkg is a full URI (or the path to a local
+// directory) rather than the name of a package of the repositories database.
+func lbx_is_pkg_uri(pkg string) bool {
+	return strings.Contains(pkg, "://") ||
+		strings.HasPrefix(pkg, "git@") ||
+		filepath.IsAbs(pkg) ||
+		strings.HasPrefix(pkg, "./") ||
+		strings.HasPrefix(pkg, "../") ||
+		strings.HasPrefix(pkg, "$")
+}
+
+// lbx_pkg_co_uri checks out the package at the full URI pkguri.
+// The temporary directory of the checkout is removed, even if ctx is done.
+func lbx_pkg_co_uri(ctx context.Context, cmd *commander.Command, pkguri, pkgvers string) error {
+	var err error
+
+	switch pkgvers {
+	case "head", "trunk":
+		pkgvers = ""
+	}
+	pkgname := cmd.Flag.Lookup("name").Value.Get().(string)
+
+	h, err := vcs.NewHelperContext(ctx, pkguri, pkgname, pkgvers, ".")
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+
+	err = h.CheckoutContext(ctx)
+	if err != nil {
+		return err
 	}
 
-	err = gp.Run()
+	g_ctx.Debugf("checked out [%s] as [%s] (%s)\n", pkguri, h.PkgName, h.Type)
 	return err
 }
 
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+)
+
+func lbx_make_cmd_pkg_create() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_pkg_create,
+		UsageLine: "create [options] <pkg>",
+		Short:     "create a new package in the current workarea",
+		Long: `
+create creates a new package with the standard layout:
+
+ <pkg>/CMakeLists.txt
+ <pkg>/cmt/requirements
+ <pkg>/doc/release.notes
+ <pkg>/options/<name>.py
+ <pkg>/python/<name>/__init__.py
+ <pkg>/src/
+
+Skeletons of Gaudi algorithms and tools can be added to the component library
+of the package with -alg and -tool.
+
+The package is a relative path (e.g. Hat/MyPackage), and the package, algorithm
+and tool names are C++ identifiers. Nothing is left behind if the package can
+not be created.
+
+The files are generated from the templates under templates/pkg.
+A template can be overridden by putting a file with the same name under
+~/.lbx/templates/pkg.
+
+ex:
+ $ lbx pkg create Phys/MyPackage
+ $ lbx pkg create -alg=MyAlg,MyOtherAlg -tool=MyTool Phys/MyPackage
+`,
+		Flag: *flag.NewFlagSet("lbx-pkg-create", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	cmd.Flag.String("version", "v1r0", "version of the new package")
+	cmd.Flag.String("alg", "", "comma-separated list of algorithms to create")
+	cmd.Flag.String("tool", "", "comma-separated list of tools to create")
+	return cmd
+}
+
+func lbx_run_cmd_pkg_create(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	if len(args) != 1 {
+		g_ctx.Errorf("lbx-pkg-create: needs 1 arg (pkg). got=%d\n", len(args))
+		return fmt.Errorf("lbx-pkg-create: invalid number of arguments")
+	}
+
+	pkgname := path.Clean(filepath.ToSlash(args[0]))
+	err = lbx_check_pkg_name(pkgname)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-create: %v\n", err)
+		return err
+	}
+	if path_exists(pkgname) {
+		g_ctx.Errorf("lbx-pkg-create: [%s] already exists\n", pkgname)
+		return fmt.Errorf("lbx-pkg-create: package already exists")
+	}
+
+	algs := lbx_split_names(cmd.Flag.Lookup("alg").Value.Get().(string))
+	tools := lbx_split_names(cmd.Flag.Lookup("tool").Value.Get().(string))
+	for _, name := range append(append([]string{}, algs...), tools...) {
+		if !lbx_cxx_ident.MatchString(name) {
+			g_ctx.Errorf("lbx-pkg-create: invalid component name %q (not a C++ identifier)\n", name)
+			return fmt.Errorf("lbx-pkg-create: invalid component name %q", name)
+		}
+	}
+
+	// remove the directories created for a package which could not be
+	// completed.
+	top := pkgname
+	for dir := path.Dir(top); dir != "." && !path_exists(dir); dir = path.Dir(dir) {
+		top = dir
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(top)
+		}
+	}()
+
+	data := map[string]interface{}{
+		"FullName":   pkgname,
+		"Package":    path.Base(pkgname),
+		"Version":    cmd.Flag.Lookup("version").Value.Get().(string),
+		"User":       lbx_user_name(),
+		"Date":       time.Now().Format("2006-01-02"),
+		"Algs":       algs,
+		"Tools":      tools,
+		"Components": len(algs)+len(tools) > 0,
+	}
+	pkg := data["Package"].(string)
+
+	files := []struct {
+		tmpl string
+		name string
+	}{
+		{"CMakeLists.txt", "CMakeLists.txt"},
+		{"requirements", filepath.Join("cmt", "requirements")},
+		{"release.notes", filepath.Join("doc", "release.notes")},
+		{"options.py", filepath.Join("options", pkg+".py")},
+		{"__init__.py", filepath.Join("python", pkg, "__init__.py")},
+	}
+
+	for _, f := range files {
+		err = lbx_pkg_create_file(f.tmpl, filepath.Join(pkgname, f.name), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Join(pkgname, "src"), 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, comp := range []struct {
+		kind  string
+		names []string
+	}{
+		{"Algorithm", algs},
+		{"Tool", tools},
+	} {
+		for _, name := range comp.names {
+			cdata := make(map[string]interface{}, len(data)+3)
+			for k, v := range data {
+				cdata[k] = v
+			}
+			cdata["Name"] = name
+			cdata["Guard"] = strings.ToUpper(name) + "_H"
+			cdata["Indent"] = strings.Repeat(" ", 2*len(name)+2)
+			for _, ext := range []string{".h", ".cpp"} {
+				oname := filepath.Join(pkgname, "src", name+ext)
+				err = lbx_pkg_create_file(comp.kind+ext, oname, cdata)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	g_ctx.Infof("created package [%s]\n", pkgname)
+	return err
+}
+
+// lbx_cxx_ident matches C++ identifiers.
+var lbx_cxx_ident = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// lbx_check_pkg_name checks that pkgname is a relative package path (e.g.
+// Hat/MyPackage) made of C++ identifiers.
+func lbx_check_pkg_name(pkgname string) error {
+	if path.IsAbs(pkgname) || filepath.IsAbs(pkgname) {
+		return fmt.Errorf("invalid package name %q (not a relative path)", pkgname)
+	}
+	for _, elem := range strings.Split(pkgname, "/") {
+		if !lbx_cxx_ident.MatchString(elem) {
+			return fmt.Errorf("invalid package name %q (%q is not a C++ identifier)", pkgname, elem)
+		}
+	}
+	return nil
+}
+
+// lbx_pkg_create_file renders the package template tmpl into the file oname.
+func lbx_pkg_create_file(tmpl, oname string, data interface{}) error {
+	fname, err := lbx_template_file(filepath.Join("pkg", tmpl))
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-create: problem locating templates: %v\n", err)
+		return err
+	}
+
+	t, err := template.New(tmpl).ParseFiles(fname)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-create: problem parsing template [%s]: %v\n", fname, err)
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(oname), 0755)
+	if err != nil {
+		return err
+	}
+
+	dest, err := os.Create(oname)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-create: error creating file [%s]: %v\n", oname, err)
+		return err
+	}
+	defer dest.Close()
+
+	err = t.Execute(dest, data)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-create: error running template [%s]: %v\n", fname, err)
+		return err
+	}
+	return dest.Close()
+}
+
+// lbx_split_names splits a comma-separated list of names, dropping empty ones.
+func lbx_split_names(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// lbx_user_name returns the full name of the current user, or its login name.
+func lbx_user_name() string {
+	usr, err := user.Current()
+	if err != nil {
+		return Getenv("USER", "unknown")
+	}
+	if usr.Name != "" {
+		return usr.Name
+	}
+	return usr.Username
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbrelease"
+)
+
+func lbx_make_cmd_pkg_ls() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_pkg_ls,
+		UsageLine: "ls [options] [<hat-prefix>]",
+		Short:     "list the packages known to the repositories",
+		Long: `
+ls lists the packages known to the repositories, with their project and repository.
+Packages already checked out in the current workarea are marked with a '*'.
+
+The list of packages is cached in the .lbx/packages-db.toml file of the
+current workarea (or in ~/.lbx/packages-db.toml outside of a workarea), for
+the given -user and -protocol. Use -refresh to query the repositories again.
+
+ex:
+ $ lbx pkg ls
+ $ lbx pkg ls Phys/
+ $ lbx pkg ls -project=Gaudi -glob='*Kernel'
+ $ lbx pkg ls -o=json Hlt
+`,
+		Flag: *flag.NewFlagSet("lbx-pkg-ls", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	cmd.Flag.String("project", "", "only list packages of this project")
+	cmd.Flag.String("glob", "", "only list packages whose name matches this glob pattern")
+	cmd.Flag.Bool("refresh", false, "refresh the cached list of packages")
+	add_repositories(cmd)
+	cmd.Flag.String("o", "text", "output format (text or json)")
+	return cmd
+}
+
+func lbx_run_cmd_pkg_ls(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	hat := ""
+	switch len(args) {
+	case 0:
+	case 1:
+		hat = args[0]
+	default:
+		g_ctx.Errorf("lbx-pkg-ls: needs at most 1 arg (hat-prefix). got=%d\n", len(args))
+		return fmt.Errorf("lbx-pkg-ls: invalid number of arguments")
+	}
+
+	proj := cmd.Flag.Lookup("project").Value.Get().(string)
+	glob := cmd.Flag.Lookup("glob").Value.Get().(string)
+	if glob != "" {
+		if _, err = filepath.Match(glob, ""); err != nil {
+			g_ctx.Errorf("lbx-pkg-ls: invalid glob pattern %q: %v\n", glob, err)
+			return err
+		}
+	}
+
+	gp := &lbrelease.GetPack{
+		Refresh:  cmd.Flag.Lookup("refresh").Value.Get().(bool),
+		User:     cmd.Flag.Lookup("user").Value.Get().(string),
+		Protocol: cmd.Flag.Lookup("protocol").Value.Get().(string),
+	}
+	pkgs, err := gp.Packages(hat)
+	if err != nil {
+		g_ctx.Errorf("lbx-pkg-ls: problem listing packages: %v\n", err)
+		return err
+	}
+
+	type entry struct {
+		Name       string `json:"name"`
+		Project    string `json:"project"`
+		Repo       string `json:"repository"`
+		CheckedOut bool   `json:"checked_out"`
+	}
+	entries := make([]entry, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if proj != "" && !strings.EqualFold(pkg.Project, proj) {
+			continue
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, pkg.Name); !ok {
+				continue
+			}
+		}
+		entries = append(entries, entry{
+			Name:       pkg.Name,
+			Project:    pkg.Project,
+			Repo:       pkg.Repo,
+			CheckedOut: lbx_pkg_checked_out(pkg.Name),
+		})
+	}
+
+	switch format := cmd.Flag.Lookup("o").Value.Get().(string); format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+		for _, e := range entries {
+			mark := " "
+			if e.CheckedOut {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s %s\t%s\t%s\n", mark, e.Name, e.Project, e.Repo)
+		}
+		err = w.Flush()
+	default:
+		return fmt.Errorf("lbx-pkg-ls: unknown output format %q", format)
+	}
+
+	return err
+}
+
+// lbx_pkg_checked_out returns whether pkg is checked out in the current
+// workarea, directly or under the directory of its git repository.
+func lbx_pkg_checked_out(pkg string) bool {
+	_, err := lbx_pkg_dir(pkg)
+	return err == nil
+}
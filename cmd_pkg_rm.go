@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbctx/vcs"
+)
+
+func lbx_make_cmd_pkg_rm() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_pkg_rm,
+		UsageLine: "rm [options] <pkg> [<pkg>...]",
+		Short:     "remove a package from the current workarea",
+		Long: `
+rm removes a package from the current workarea.
+
+Packages with uncommitted changes, untracked files or commits not pushed
+upstream are NOT removed, unless -f is given.
+Packages checked out with git are also removed from the sparse-checkout
+selection of their repository.
+
+ex:
+ $ lbx pkg rm Phys/DaVinciKernel
+ $ lbx pkg rm -f Phys/DaVinciKernel
+`,
+		Flag: *flag.NewFlagSet("lbx-pkg-rm", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	cmd.Flag.Bool("f", false, "force the removal, even if local changes would be lost")
+	return cmd
+}
+
+func lbx_run_cmd_pkg_rm(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	if len(args) <= 0 {
+		g_ctx.Errorf("lbx-pkg-rm: needs at least 1 arg (pkg). got=%d\n", len(args))
+		return fmt.Errorf("lbx-pkg-rm: invalid number of arguments")
+	}
+
+	force := cmd.Flag.Lookup("f").Value.Get().(bool)
+
+	nerrs := 0
+	for _, pkg := range args {
+		dir, err := lbx_pkg_dir(pkg)
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-rm: %v\n", err)
+			nerrs++
+			continue
+		}
+		err = vcs.RemovePackage(dir, force)
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-rm: could not remove [%s]: %v\n", pkg, err)
+			nerrs++
+			continue
+		}
+		g_ctx.Infof("removed [%s]\n", dir)
+	}
+
+	if nerrs > 0 {
+		err = fmt.Errorf("lbx-pkg-rm: %d package(s) could not be removed", nerrs)
+	}
+	return err
+}
+
+// lbx_pkg_dir locates a package in the current workarea.
+// The package may be directly under the workarea, or under the directory of
+// the git repository holding it.
+func lbx_pkg_dir(pkg string) (string, error) {
+	if path_exists(pkg) {
+		return pkg, nil
+	}
+	matches, err := filepath.Glob(filepath.Join("*", pkg))
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no such package [%s] in workarea", pkg)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("ambiguous package [%s] in workarea: %v", pkg, matches)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbctx"
+	"github.com/lhcb-org/lbx/lbctx/vcs"
+)
+
+func lbx_make_cmd_pkg_update() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_pkg_update,
+		UsageLine: "update [options] [<pkg>...]",
+		Short:     "update packages of the current workarea",
+		Long: `
+update pulls the upstream changes of packages of the current workarea, or
+switches them to another tag with -tag.
+All the packages of the workarea are updated when none is given.
+
+svn packages are switched to the tags/ path of their repository (use
+-tag=head to go back to the trunk). git packages are synced to the git tag or
+branch: all the packages checked out from the same git repository are switched.
+Without -tag, detached git checkouts (e.g. of a tag) are left as they are.
+The packages of the same git repository are updated once, together.
+
+Conflicts are reported for each package and do not stop the update of the
+other packages.
+
+ex:
+ $ lbx pkg update
+ $ lbx pkg update Phys/DaVinciKernel
+ $ lbx pkg update -tag=v10r2 Phys/DaVinciKernel
+`,
+		Flag: *flag.NewFlagSet("lbx-pkg-update", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	cmd.Flag.String("tag", "", "tag (or branch) to switch the packages to")
+	return cmd
+}
+
+func lbx_run_cmd_pkg_update(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	tag := cmd.Flag.Lookup("tag").Value.Get().(string)
+
+	pkgs := args
+	if len(pkgs) == 0 {
+		pkgs, err = lbctx.WorkAreaPackages(".")
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-update: problem listing packages: %v\n", err)
+			return err
+		}
+	}
+
+	nerrs := 0
+	nconflicts := 0
+	roots := make(map[string]string) // repository root -> package updated with it
+	for _, pkg := range pkgs {
+		dir, err := lbx_pkg_dir(pkg)
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-update: %v\n", err)
+			nerrs++
+			continue
+		}
+		root, err := vcs.RepositoryRoot(dir)
+		if err != nil {
+			g_ctx.Errorf("lbx-pkg-update: %v\n", err)
+			nerrs++
+			continue
+		}
+		if first, dup := roots[root]; dup {
+			g_ctx.Infof("[%s] updated together with [%s]\n", pkg, first)
+			continue
+		}
+		roots[root] = pkg
+
+		err = vcs.UpdatePackage(dir, tag)
+		if err != nil {
+			if cerr, ok := err.(*vcs.ConflictError); ok {
+				g_ctx.Errorf("lbx-pkg-update: conflicts in [%s]:\n", pkg)
+				for _, fname := range cerr.Files {
+					g_ctx.Errorf("    %s\n", fname)
+				}
+				nconflicts++
+				continue
+			}
+			g_ctx.Errorf("lbx-pkg-update: could not update [%s]: %v\n", pkg, err)
+			nerrs++
+			continue
+		}
+		g_ctx.Infof("updated [%s]\n", dir)
+	}
+
+	switch {
+	case nerrs > 0 && nconflicts > 0:
+		err = fmt.Errorf("lbx-pkg-update: %d package(s) could not be updated, %d package(s) with conflicts", nerrs, nconflicts)
+	case nerrs > 0:
+		err = fmt.Errorf("lbx-pkg-update: %d package(s) could not be updated", nerrs)
+	case nconflicts > 0:
+		err = fmt.Errorf("lbx-pkg-update: %d package(s) with conflicts", nconflicts)
+	}
+	return err
+}
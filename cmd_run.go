@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"runtime"
@@ -25,13 +26,18 @@ ex:
  hello
 
  $ lbx run gaudi.py some/jobo.py
+
+Problems in the environment XML files are reported with their position:
+ $ lbx run -strict-env echo "hello"
+ GaudiEnvironment.xml:4:3: error: <env:set>: unknown attribute "colour"
+  <env:set variable="A" colour="x">1</env:set>
+  ^
+Unknown elements and attributes are only warnings without -strict-env.
 `,
 		Flag: *flag.NewFlagSet("lbx-run", flag.ExitOnError),
 	}
 	add_output_level(cmd)
-	cmd.Flag.Bool("use-grid", false, "enable auto selection of LHCbGrid project")
-	cmd.Flag.String("runtime-projects", "", "comma-separated list of runtime projects to add to the environment (e.g.: \"Foo:v1r2,Bar,Baz:v42\"")
-	cmd.Flag.String("overriding-projects", "", "comma-separated list of projects to override packages (e.g: \"Foo:v1r2,Bar,Baz:v42\")")
+	add_runtime_projects(cmd)
 	return cmd
 }
 
@@ -45,6 +51,34 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 	default:
 	}
 
+	env, err := lbx_runtime_env(cmd, g_ctx.Project, g_ctx.Version)
+	if err != nil {
+		return err
+	}
+
+	// extend the prompt variable
+	ps1 := os.Getenv("PS1")
+	err = env.Set("PS1", fmt.Sprintf("[%s %s] %s", g_ctx.Project, g_ctx.Version, ps1))
+	if err != nil {
+		return err
+	}
+
+	bin := exec.Command(args[0], args[1:]...)
+	bin.Env = env.Env()
+	bin.Stdin = os.Stdin
+	bin.Stdout = os.Stdout
+	bin.Stderr = os.Stderr
+
+	//fmt.Printf("sub-command: %v\n", bin.Args)
+	err = bin.Run()
+	return err
+}
+
+// lbx_runtime_env builds the runtime environment of a project, taking into
+// account the -use-grid, -runtime-projects and -overriding-projects flags.
+func lbx_runtime_env(cmd *commander.Command, project, version string) (*lbenv.Environment, error) {
+	var err error
+
 	type pair struct {
 		Project string
 		Version string
@@ -73,8 +107,8 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 	}
 
 	projects = append(projects, pair{
-		Project: g_ctx.Project,
-		Version: g_ctx.Version,
+		Project: project,
+		Version: version,
 	})
 
 	for _, p := range strings.Split(cmd.Flag.Lookup("runtime-projects").Value.Get().(string), ",") {
@@ -98,13 +132,16 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 	// set the environment XML search path
 	xmlenvpath := make([]string, 0, len(projects))
 	for _, p := range projects {
-		// FIXME: use ExpandVersionAlias
 		proj := p.Project
-		vers := p.Version
+		vers, err := g_ctx.ExpandVersionAlias(proj, p.Version)
+		if err != nil {
+			g_ctx.Errorf("lbx: error resolving version of %s: %v\n", proj, err)
+			return nil, err
+		}
 		paths, err := g_ctx.EnvXMLPath(proj, vers, g_ctx.Platform)
 		if err != nil {
-			g_ctx.Errorf("lbx-run: error looking up ENVXMLPATH: %v\n", err)
-			return err
+			g_ctx.Errorf("lbx: error looking up ENVXMLPATH: %v\n", err)
+			return nil, err
 		}
 		xmlenvpath = append(xmlenvpath, paths...)
 	}
@@ -113,6 +150,7 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 	env := lbenv.New()
 	env.SearchPath = xmlenvpath
 	env.LoadFromSystem = true
+	env.Strict = cmd.Flag.Lookup("strict-env").Value.Get().(bool)
 
 	// load from environment
 	for _, val := range os.Environ() {
@@ -121,8 +159,8 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 		v := os.Getenv(k)
 		err = env.Set(k, v)
 		if err != nil {
-			g_ctx.Errorf("lbx-run: problem initializing env.var %q: %v\n", k, err)
-			return err
+			g_ctx.Errorf("lbx: problem initializing env.var %q: %v\n", k, err)
+			return nil, err
 		}
 	}
 
@@ -132,9 +170,14 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 	for _, p := range projects {
 		name := p.Project + "Environment.xml"
 		err = env.LoadXMLByName(name)
+		lbx_print_env_warnings(env)
 		if err != nil {
-			g_ctx.Errorf("lbx-run: problem loading [%s]: %v\n", name, err)
-			return err
+			if derr, ok := err.(*lbenv.DecodeError); ok {
+				lbx_print_env_diag("error", derr)
+			} else {
+				g_ctx.Errorf("lbx: problem loading [%s]: %v\n", name, err)
+			}
+			return nil, err
 		}
 	}
 
@@ -158,28 +201,68 @@ func lbx_run_cmd_run(cmd *commander.Command, args []string) error {
 			}
 
 			if err != nil {
-				return err
+				return nil, err
 			}
 			err = env.Unset("LD_LIBRARY_PATH")
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
-	// extend the prompt variable
-	ps1 := os.Getenv("PS1")
-	err = env.Set("PS1", fmt.Sprintf("[%s %s] %s", g_ctx.Project, g_ctx.Version, ps1))
-	if err != nil {
-		return err
+
+	return env, err
+}
+
+// lbx_print_env_warnings prints and clears the warnings collected while
+// loading the environment XML files.
+func lbx_print_env_warnings(env *lbenv.Environment) {
+	for _, w := range env.Warnings {
+		lbx_print_env_diag("warning", w)
 	}
+	env.Warnings = env.Warnings[:0]
+}
 
-	bin := exec.Command(args[0], args[1:]...)
-	bin.Env = env.Env()
-	bin.Stdin = os.Stdin
-	bin.Stdout = os.Stdout
-	bin.Stderr = os.Stderr
+// lbx_print_env_diag prints a problem of an environment XML file the way a
+// compiler would: the position, the message, and the offending source line
+// with a caret under the column.
+func lbx_print_env_diag(kind string, derr *lbenv.DecodeError) {
+	printf := g_ctx.Errorf
+	if kind == "warning" {
+		printf = g_ctx.Warnf
+	}
 
-	//fmt.Printf("sub-command: %v\n", bin.Args)
-	err = bin.Run()
-	return err
+	file := derr.File
+	if file == "" {
+		file = "<input>"
+	}
+	msg := derr.Msg
+	if derr.Element != "" {
+		msg = fmt.Sprintf("<env:%s>: %s", derr.Element, msg)
+	}
+	printf("%s:%d:%d: %s: %s\n", file, derr.Line, derr.Column, kind, msg)
+
+	if derr.File == "" || derr.Line <= 0 {
+		return
+	}
+	buf, err := ioutil.ReadFile(derr.File)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(string(buf), "\n")
+	if derr.Line > len(lines) {
+		return
+	}
+	line := strings.TrimRight(lines[derr.Line-1], "\r")
+	printf(" %s\n", line)
+	if derr.Column <= 0 || derr.Column > len(line)+1 {
+		return
+	}
+	// keep the tabs so the caret lines up with the source line.
+	pad := []byte(line[:derr.Column-1])
+	for i, c := range pad {
+		if c != '\t' {
+			pad[i] = ' '
+		}
+	}
+	printf(" %s^\n", string(pad))
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbenv"
+)
+
+func lbx_make_cmd_shell() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_shell,
+		UsageLine: "shell [options] [<project-name> [<project-version>]]",
+		Short:     "start an interactive shell with the proper runtime environment",
+		Long: `
+shell starts an interactive shell with the runtime environment 'lbx run' would use.
+
+By default, the project, version and platform of the current workarea are used.
+The prompt is prefixed with the project name and version for bash, zsh, tcsh and fish.
+
+The LBX_SHELL and LBX_SHELL_LEVEL environment variables are exported to the
+sub-shell. lbx shell refuses to start from within another lbx shell, unless
+-nested is given.
+
+ex:
+ $ lbx shell
+ $ lbx shell DaVinci v34r1
+ $ lbx shell -shell=/bin/zsh Gaudi
+`,
+		Flag: *flag.NewFlagSet("lbx-shell", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	add_runtime_projects(cmd)
+	add_platform(cmd)
+	cmd.Flag.String("shell", Getenv("SHELL", "/bin/sh"), "path to the shell to start")
+	cmd.Flag.Bool("nested", false, "allow starting a shell from within another lbx shell")
+	return cmd
+}
+
+func lbx_run_cmd_shell(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	proj, vers, err := lbx_env_project(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	level := 0
+	if cur := os.Getenv("LBX_SHELL"); cur != "" {
+		if !cmd.Flag.Lookup("nested").Value.Get().(bool) {
+			g_ctx.Errorf("lbx-shell: already running inside an lbx shell for [%s]. (use -nested to force)\n", cur)
+			return fmt.Errorf("lbx-shell: nested shell")
+		}
+		level, _ = strconv.Atoi(os.Getenv("LBX_SHELL_LEVEL"))
+	}
+
+	shell, err := exec.LookPath(cmd.Flag.Lookup("shell").Value.Get().(string))
+	if err != nil {
+		g_ctx.Errorf("lbx-shell: could not locate shell: %v\n", err)
+		return err
+	}
+
+	env, err := lbx_runtime_env(cmd, proj, vers)
+	if err != nil {
+		return err
+	}
+
+	err = env.Set("LBX_SHELL", proj+" "+vers)
+	if err != nil {
+		return err
+	}
+	err = env.Set("LBX_SHELL_LEVEL", strconv.Itoa(level+1))
+	if err != nil {
+		return err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "lbx-shell-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	prefix := fmt.Sprintf("[%s %s] ", proj, vers)
+	shargs, err := lbx_shell_setup(env, shell, prefix, tmpdir)
+	if err != nil {
+		g_ctx.Errorf("lbx-shell: problem setting up the shell: %v\n", err)
+		return err
+	}
+
+	g_ctx.Infof("starting %s for [%s %s]...\n", shell, proj, vers)
+	bin := exec.Command(shell, shargs...)
+	bin.Env = env.Env()
+	bin.Stdin = os.Stdin
+	bin.Stdout = os.Stdout
+	bin.Stderr = os.Stderr
+
+	err = bin.Run()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// the exit status of the shell is the one of the last command
+			// run by the user: not an lbx error.
+			return nil
+		}
+	}
+	return err
+}
+
+// lbx_shell_setup prepares the start-up files of the interactive shell so the
+// prompt is prefixed with prefix, and returns the arguments to start it with.
+// The user's own start-up files are still loaded.
+func lbx_shell_setup(env *lbenv.Environment, shell, prefix, tmpdir string) ([]string, error) {
+	var err error
+	home := os.Getenv("HOME")
+	sh := lbenv.ShType
+
+	switch filepath.Base(shell) {
+	case "bash":
+		rcfile := filepath.Join(tmpdir, "bashrc")
+		err = ioutil.WriteFile(rcfile, []byte(fmt.Sprintf(
+			`if [ -f ~/.bashrc ]; then . ~/.bashrc; fi
+PS1=%s"$PS1"
+`,
+			lbenv.Quote(sh, prefix),
+		)), 0644)
+		return []string{"--rcfile", rcfile, "-i"}, err
+
+	case "zsh":
+		zdotdir := Getenv("ZDOTDIR", home)
+		err = ioutil.WriteFile(filepath.Join(tmpdir, ".zshenv"), []byte(fmt.Sprintf(
+			`ZDOTDIR=%[1]s
+if [ -f "$ZDOTDIR/.zshenv" ]; then . "$ZDOTDIR/.zshenv"; fi
+ZDOTDIR=%[2]s
+`,
+			lbenv.Quote(sh, zdotdir), lbenv.Quote(sh, tmpdir),
+		)), 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(filepath.Join(tmpdir, ".zshrc"), []byte(fmt.Sprintf(
+			`ZDOTDIR=%[1]s
+if [ -f "$ZDOTDIR/.zshrc" ]; then . "$ZDOTDIR/.zshrc"; fi
+PS1=%[2]s"$PS1"
+`,
+			lbenv.Quote(sh, zdotdir), lbenv.Quote(sh, prefix),
+		)), 0644)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"-i"}, env.Set("ZDOTDIR", tmpdir)
+
+	case "tcsh", "csh":
+		// (t)csh has no option to select its start-up file: use a
+		// temporary HOME restoring the original one.
+		csh := lbenv.CshType
+		err = ioutil.WriteFile(filepath.Join(tmpdir, ".tcshrc"), []byte(fmt.Sprintf(
+			`setenv HOME %[1]s
+set home=%[1]s
+if ( -f ~/.tcshrc ) then
+  source ~/.tcshrc
+else if ( -f ~/.cshrc ) then
+  source ~/.cshrc
+endif
+set prompt=%[2]s"$prompt"
+`,
+			lbenv.Quote(csh, home), lbenv.Quote(csh, prefix),
+		)), 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Link(filepath.Join(tmpdir, ".tcshrc"), filepath.Join(tmpdir, ".cshrc"))
+		if err != nil {
+			return nil, err
+		}
+		return []string{"-i"}, env.Set("HOME", tmpdir)
+
+	case "fish":
+		fish := lbenv.FishType
+		return []string{"-i", "-C", fmt.Sprintf(
+			"functions -c fish_prompt __lbx_fish_prompt; function fish_prompt; echo -n %s; __lbx_fish_prompt; end",
+			lbenv.Quote(fish, prefix),
+		)}, nil
+	}
+
+	// sh, dash, ksh, ...: these read PS1 from the environment.
+	return []string{"-i"}, env.Set("PS1", prefix+os.Getenv("PS1"))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/gonuts/commander"
+	"github.com/gonuts/flag"
+	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbctx"
+	"github.com/lhcb-org/lbx/lbctx/vcs"
+)
+
+func lbx_make_cmd_status() *commander.Command {
+	cmd := &commander.Command{
+		Run:       lbx_run_cmd_status,
+		UsageLine: "status [options]",
+		Short:     "summarize the state of the packages of the current workarea",
+		Long: `
+status lists the packages checked out in the current workarea with, for each
+of them, the version control system, the tag or branch, the revision recorded
+at checkout time, the number of locally modified files and how many commits
+the package is ahead of or behind its upstream.
+
+git packages are compared with their remote-tracking branches, as last fetched.
+svn packages are only compared with their repository when -remote is given.
+
+ex:
+ $ lbx status
+ $ lbx status -remote
+ $ lbx status -o=json
+`,
+		Flag: *flag.NewFlagSet("lbx-status", flag.ExitOnError),
+	}
+	add_output_level(cmd)
+	cmd.Flag.Bool("remote", false, "contact the svn repositories to find out-of-date packages")
+	cmd.Flag.String("o", "text", "output format (text or json)")
+	return cmd
+}
+
+func lbx_run_cmd_status(cmd *commander.Command, args []string) error {
+	var err error
+
+	g_ctx.SetLevel(logger.Level(cmd.Flag.Lookup("lvl").Value.Get().(int)))
+
+	if len(args) != 0 {
+		g_ctx.Errorf("lbx-status: takes no argument. got=%d\n", len(args))
+		return fmt.Errorf("lbx-status: invalid number of arguments")
+	}
+
+	if g_ctx.Project == "" {
+		g_ctx.Errorf("lbx-status: not in a workarea (no .lbx/config.toml)\n")
+		return fmt.Errorf("lbx-status: not in a workarea")
+	}
+
+	format := cmd.Flag.Lookup("o").Value.Get().(string)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("lbx-status: unknown output format %q", format)
+	}
+
+	remote := cmd.Flag.Lookup("remote").Value.Get().(bool)
+
+	pkgs, err := lbctx.WorkAreaPackages(".")
+	if err != nil {
+		g_ctx.Errorf("lbx-status: problem listing packages: %v\n", err)
+		return err
+	}
+
+	type entry struct {
+		Name string `json:"name"`
+		vcs.Status
+		Error string `json:"error,omitempty"`
+	}
+
+	entries := make([]entry, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		st, err := vcs.PackageStatus(pkg, remote)
+		e := entry{Name: pkg, Status: st}
+		if err != nil {
+			g_ctx.Warnf("lbx-status: problem retrieving status of [%s]: %v\n", pkg, err)
+			e.Error = err.Error()
+		}
+		entries = append(entries, e)
+	}
+
+	switch format {
+	case "json":
+		doc := struct {
+			Project  string  `json:"project"`
+			Version  string  `json:"version"`
+			Platform string  `json:"platform"`
+			Packages []entry `json:"packages"`
+		}{g_ctx.Project, g_ctx.Version, g_ctx.Platform, entries}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(doc)
+	case "text":
+		fmt.Printf("workarea for [%s %s] (%s)\n", g_ctx.Project, g_ctx.Version, g_ctx.Platform)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "PACKAGE\tVCS\tTAG\tREVISION\tMODIFIED\tAHEAD\tBEHIND\n")
+		for _, e := range entries {
+			ahead, behind := "-", "-"
+			if e.Upstream != "" || e.VCS == "git" {
+				ahead = strconv.Itoa(e.Ahead)
+			}
+			if e.Upstream != "" {
+				behind = strconv.Itoa(e.Behind)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				e.Name,
+				lbx_or_dash(e.VCS),
+				lbx_or_dash(e.Tag),
+				lbx_or_dash(e.Revision),
+				len(e.Modified),
+				ahead, behind,
+			)
+		}
+		err = w.Flush()
+	}
+
+	return err
+}
+
+// lbx_or_dash returns s, or "-" if s is empty.
+func lbx_or_dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
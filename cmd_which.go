@@ -2,10 +2,14 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/gonuts/commander"
 	"github.com/gonuts/flag"
 	"github.com/gonuts/logger"
+	"github.com/lhcb-org/lbx/lbctx"
 )
 
 func lbx_make_cmd_which() *commander.Command {
@@ -22,6 +26,9 @@ ex:
 
  $ lbx which GAUDI GaudiKernel
  /afs/cern.ch/sw/Gaudi/releases/GAUDI/GAUDI_v25r2/GaudiKernel
+
+ $ lbx which -d GAUDI GaudiKernel v25r2
+ /afs/cern.ch/sw/Gaudi/releases/GAUDI/GAUDI_v25r2/GaudiKernel/cmt
 `,
 		Flag: *flag.NewFlagSet("lbx-which", flag.ExitOnError),
 	}
@@ -56,6 +63,81 @@ func lbx_run_cmd_which(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("lbx-which: invalid number of arguments")
 	}
 
+	nocase := cmd.Flag.Lookup("i").Value.Get().(bool)
+	confdir := cmd.Flag.Lookup("d").Value.Get().(bool)
+	userarea := cmd.Flag.Lookup("user-area").Value.Get().(bool)
+
+	if vers == "" {
+		vers = "latest"
+	}
+	if nocase {
+		proj = lbctx.FixProjectCase(proj)
+	}
+
+	if dir := os.Getenv("User_release_area"); dir != "" {
+		paths := make([]string, 0, len(g_ctx.ProjectsPath)+1)
+		if userarea {
+			paths = append(paths, dir)
+		}
+		for _, p := range g_ctx.ProjectsPath {
+			if p == dir {
+				continue
+			}
+			paths = append(paths, p)
+		}
+		g_ctx.ProjectsPath = paths
+	}
+
+	vers, err = g_ctx.ExpandVersionAlias(proj, vers)
+	if err != nil {
+		g_ctx.Errorf("lbx-which: %v\n", err)
+		return err
+	}
+
 	g_ctx.Infof("which project=%q package=%q version=%q\n", proj, pkg, vers)
+
+	dir, err := g_ctx.FindProjectDir(proj, vers, nocase)
+	if err != nil {
+		g_ctx.Errorf("lbx-which: %v\n", err)
+		return fmt.Errorf(
+			"lbx-which: project %q (version=%q) not found in [%s]",
+			proj, vers, strings.Join(g_ctx.ProjectsPath, string(os.PathListSeparator)),
+		)
+	}
+
+	if pkg != "" {
+		dir, err = g_ctx.FindPackageDir(dir, pkg, nocase)
+		if err != nil {
+			g_ctx.Errorf("lbx-which: %v\n", err)
+			return fmt.Errorf("lbx-which: package %q not found", pkg)
+		}
+	}
+
+	if confdir {
+		dir, err = which_confdir(dir)
+		if err != nil {
+			g_ctx.Errorf("lbx-which: %v\n", err)
+			return err
+		}
+	}
+
+	fmt.Printf("%s\n", dir)
 	return err
 }
+
+// which_confdir returns the cmt or cmake directory of a project or package.
+func which_confdir(dir string) (string, error) {
+	for _, sub := range []string{"cmt", "cmake"} {
+		confdir := filepath.Join(dir, sub)
+		if path_exists(confdir) {
+			return confdir, nil
+		}
+	}
+
+	// CMake packages are configured from their top-level CMakeLists.txt
+	if path_exists(filepath.Join(dir, "CMakeLists.txt")) {
+		return dir, nil
+	}
+
+	return "", fmt.Errorf("lbx-which: no cmt nor cmake directory under [%s]", dir)
+}
@@ -0,0 +1,178 @@
+package lbctx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProjectVersions returns the list of release versions of a project
+// installed under Context.ProjectsPath, newest first.
+// Both LHCb-style (vXrY) and numeric (X.Y.Z) releases are listed.
+func (ctx *Context) ProjectVersions(name string) []string {
+	prefixes := []string{
+		name + "_",
+		filepath.Join(strings.ToUpper(name), strings.ToUpper(name)+"_"),
+	}
+
+	set := make(map[string]struct{})
+	versions := make(Versions, 0)
+	for _, path := range ctx.ProjectsPath {
+		for _, prefix := range prefixes {
+			// the version parser tells the releases from the other entries
+			// (e.g. NAME_prod or NAME_HEAD).
+			matches, err := filepath.Glob(filepath.Join(path, prefix) + "*")
+			if err != nil {
+				continue
+			}
+			for _, dir := range matches {
+				if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+					continue
+				}
+				vers := ParseVersion(strings.TrimPrefix(filepath.Base(dir), filepath.Base(prefix)))
+				if !vers.IsRelease() {
+					continue
+				}
+				if _, dup := set[vers.String()]; dup {
+					continue
+				}
+				set[vers.String()] = struct{}{}
+				versions = append(versions, vers)
+			}
+		}
+	}
+
+	sort.Sort(sort.Reverse(versions))
+
+	strs := make([]string, len(versions))
+	for i, vers := range versions {
+		strs[i] = vers.String()
+	}
+	return strs
+}
+
+// ExpandVersionAlias maps a version alias of a project to a concrete version.
+//
+// The following aliases are understood:
+//   - "latest": the newest installed release,
+//   - "prod": the release pointed at by the NAME_prod link, or the newest one,
+//   - "head": the HEAD version,
+//   - glob patterns (e.g. "v45r*"): the newest matching release,
+//   - comparisons (e.g. ">=v44r0" or ">=v44r0,<v45r0"): the newest release
+//     satisfying all the constraints.
+//
+// Any other version is returned unchanged.
+// If "latest" can not be resolved, it is returned unchanged so the plain
+// project name can still be used as a fallback by FindProject.
+func (ctx *Context) ExpandVersionAlias(name, version string) (string, error) {
+	switch {
+	case version == "latest":
+		versions := ctx.ProjectVersions(name)
+		if len(versions) <= 0 {
+			return version, nil
+		}
+		return versions[0], nil
+
+	case version == "prod":
+		if vers, ok := ctx.prodVersion(name); ok {
+			return vers, nil
+		}
+		return ctx.ExpandVersionAlias(name, "latest")
+
+	case strings.ToUpper(version) == "HEAD":
+		return "HEAD", nil
+
+	case strings.ContainsAny(version, "*?["):
+		for _, vers := range ctx.ProjectVersions(name) {
+			if ok, err := filepath.Match(version, vers); ok && err == nil {
+				return vers, nil
+			}
+		}
+
+	case strings.ContainsAny(version, "<>="):
+		cmps, err := parseConstraints(version)
+		if err != nil {
+			return "", err
+		}
+	loop:
+		for _, vers := range ctx.ProjectVersions(name) {
+			for _, cmp := range cmps {
+				if !cmp(vers) {
+					continue loop
+				}
+			}
+			return vers, nil
+		}
+
+	default:
+		return version, nil
+	}
+
+	return "", fmt.Errorf(
+		"lbx: no version of project %q matching %q in:\n%s",
+		name, version, fmtPaths(ctx.ProjectsPath),
+	)
+}
+
+// prodVersion returns the version pointed at by the NAME_prod link, if any.
+func (ctx *Context) prodVersion(name string) (string, bool) {
+	for _, path := range ctx.ProjectsPath {
+		for _, link := range []string{
+			filepath.Join(path, name+"_prod"),
+			filepath.Join(path, strings.ToUpper(name), strings.ToUpper(name)+"_prod"),
+		} {
+			dir, err := filepath.EvalSymlinks(link)
+			if err != nil {
+				continue
+			}
+			base := filepath.Base(dir)
+			idx := strings.LastIndex(base, "_")
+			if idx < 0 {
+				continue
+			}
+			if vers := base[idx+1:]; vers != "prod" {
+				return vers, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseConstraints parses a comma-separated list of version comparisons.
+func parseConstraints(str string) ([]func(vers string) bool, error) {
+	cmps := make([]func(vers string) bool, 0, 2)
+	for _, tok := range strings.Split(str, ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "" {
+			continue
+		}
+		// the operator is made of the leading comparison characters.
+		n := len(tok) - len(strings.TrimLeft(tok, "<>=!~"))
+		op := tok[:n]
+		ref := ParseVersion(strings.TrimSpace(tok[n:]))
+		if !ref.IsRelease() {
+			return nil, fmt.Errorf("lbx: invalid version constraint %q", tok)
+		}
+		var cmp func(vers string) bool
+		switch op {
+		case "<":
+			cmp = func(vers string) bool { return ParseVersion(vers).Compare(ref) < 0 }
+		case "<=":
+			cmp = func(vers string) bool { return ParseVersion(vers).Compare(ref) <= 0 }
+		case ">":
+			cmp = func(vers string) bool { return ParseVersion(vers).Compare(ref) > 0 }
+		case ">=":
+			cmp = func(vers string) bool { return ParseVersion(vers).Compare(ref) >= 0 }
+		case "=", "==":
+			cmp = func(vers string) bool { return ParseVersion(vers).Compare(ref) == 0 }
+		case "!=":
+			cmp = func(vers string) bool { return ParseVersion(vers).Compare(ref) != 0 }
+		default:
+			return nil, fmt.Errorf("lbx: invalid version constraint %q", tok)
+		}
+		cmps = append(cmps, cmp)
+	}
+	return cmps, nil
+}
@@ -0,0 +1,153 @@
+package lbctx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gonuts/logger"
+)
+
+func TestExpandVersionAlias(t *testing.T) {
+	top, err := ioutil.TempDir("", "lbctx-alias-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	for _, dir := range []string{
+		"DaVinci_v9r3",
+		"DaVinci_v10r1",
+		"DAVINCI/DAVINCI_v10r1p2",
+		"DAVINCI/DAVINCI_v33r9",
+		"DAVINCI/DAVINCI_HEAD",
+		"DaVinci_v34r1",
+	} {
+		err = os.MkdirAll(filepath.Join(top, dir), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	err = os.Symlink(
+		filepath.Join(top, "DAVINCI", "DAVINCI_v33r9"),
+		filepath.Join(top, "DAVINCI", "DAVINCI_prod"),
+	)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	ctx := &Context{
+		msg:          logger.New("test"),
+		ProjectsPath: []string{top},
+	}
+
+	for _, table := range []struct {
+		alias string
+		want  string
+	}{
+		{"latest", "v34r1"},
+		{"prod", "v33r9"},
+		{"head", "HEAD"},
+		{"v10r*", "v10r1p2"},
+		{"v9r3", "v9r3"},
+		{">=v10r0", "v34r1"},
+		{">=v10r0,<v33r0", "v10r1p2"},
+		{"<v10r1p2", "v10r1"},
+		{"==v10r1", "v10r1"},
+	} {
+		vers, err := ctx.ExpandVersionAlias("DaVinci", table.alias)
+		if err != nil {
+			t.Fatalf("alias %q: error: %v", table.alias, err)
+		}
+		if vers != table.want {
+			t.Fatalf("alias %q: expected %q. got=%q", table.alias, table.want, vers)
+		}
+	}
+
+	for _, alias := range []string{"v11r*", ">v34r1", ">=foo"} {
+		_, err := ctx.ExpandVersionAlias("DaVinci", alias)
+		if err == nil {
+			t.Fatalf("alias %q: expected an error", alias)
+		}
+	}
+
+	// numeric releases
+	for _, dir := range []string{
+		"Gauss_v48r3",
+		"Gauss_49.1",
+		"GAUSS/GAUSS_50.0.1",
+		"GAUSS/GAUSS_50.1",
+		"Gauss_latest",
+	} {
+		err = os.MkdirAll(filepath.Join(top, dir), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	for _, table := range []struct {
+		alias string
+		want  string
+	}{
+		{"latest", "50.1"},
+		{"50.0.*", "50.0.1"},
+		{">=49.0,<50.0", "49.1"},
+		{"<49.0", "v48r3"},
+	} {
+		vers, err := ctx.ExpandVersionAlias("Gauss", table.alias)
+		if err != nil {
+			t.Fatalf("alias %q: error: %v", table.alias, err)
+		}
+		if vers != table.want {
+			t.Fatalf("alias %q: expected %q. got=%q", table.alias, table.want, vers)
+		}
+	}
+	if got, want := ctx.ProjectVersions("Gauss"), []string{"50.1", "50.0.1", "49.1", "v48r3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected versions %q. got=%q", want, got)
+	}
+
+	vers, err := ctx.ExpandVersionAlias("Moore", "latest")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if vers != "latest" {
+		t.Fatalf("expected unresolved 'latest' to be kept. got=%q", vers)
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+	for _, table := range []struct {
+		cstr string
+		vers map[string]bool // whether each version satisfies the constraints
+	}{
+		{">=20.1", map[string]bool{"20.1": true, "20.0.9": false, "21": true, "20.1.1": true}},
+		{"< 20.1", map[string]bool{"20.0": true, "20.1": false}},
+		{"==v26r3g1", map[string]bool{"v26r3g1": true, "v26r3": false, "v26r3p1g1": false}},
+		{">v26r3g1", map[string]bool{"v26r3g2": true, "v26r3p1": true, "v26r3": false}},
+		{">=v26r3g1,<v27r0", map[string]bool{"v26r3g1": true, "v26r9": true, "v27r0": false}},
+		{"!=v1r0", map[string]bool{"v1r0": false, "v1r1": true}},
+		{"=1.2.3", map[string]bool{"1.2.3": true, "1.2": false}},
+	} {
+		cmps, err := parseConstraints(table.cstr)
+		if err != nil {
+			t.Errorf("%q: error: %v", table.cstr, err)
+			continue
+		}
+		for vers, want := range table.vers {
+			got := true
+			for _, cmp := range cmps {
+				got = got && cmp(vers)
+			}
+			if got != want {
+				t.Errorf("%q: expected %s to match=%v. got=%v", table.cstr, vers, want, got)
+			}
+		}
+	}
+
+	for _, cstr := range []string{"20.1", "~v1r0", "=>v1r0", ">=foo", ">=", "<<v1r0"} {
+		if _, err := parseConstraints(cstr); err == nil {
+			t.Errorf("%q: expected an error", cstr)
+		}
+	}
+}
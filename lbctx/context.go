@@ -2,6 +2,7 @@ package lbctx
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -87,10 +88,9 @@ func defaultProjectsPath() []string {
 	return projpath
 }
 
-// FindProject finds a Gaudi-based project among the Context.ProjectsPath.
-func (ctx *Context) FindProject(name, version, platform string) (string, error) {
-
-	// standard project suffixes
+// projectSuffixes returns the standard directory suffixes under which a
+// project name/version may be installed.
+func projectSuffixes(name, version string) []string {
 	suffixes := []string{
 		fmt.Sprintf("%s_%s", name, version),
 		filepath.Join(
@@ -103,10 +103,15 @@ func (ctx *Context) FindProject(name, version, platform string) (string, error)
 	if version == "latest" {
 		suffixes = append([]string{name}, suffixes...)
 	}
+	return suffixes
+}
+
+// FindProject finds a Gaudi-based project among the Context.ProjectsPath.
+func (ctx *Context) FindProject(name, version, platform string) (string, error) {
 
 	bindir := filepath.Join("InstallArea", platform)
 	for _, path := range ctx.ProjectsPath {
-		for _, suffix := range suffixes {
+		for _, suffix := range projectSuffixes(name, version) {
 			dir := filepath.Join(path, suffix, bindir)
 			ctx.Debugf("checking [%s]...\n", dir)
 			_, err := os.Stat(dir)
@@ -123,3 +128,71 @@ func (ctx *Context) FindProject(name, version, platform string) (string, error)
 		name, version, platform, ctx.ProjectsPath,
 	)
 }
+
+// FindProjectDir finds the top-level directory of a project among the
+// Context.ProjectsPath, irrespective of the platforms it was built for.
+// If nocase is true, the project name is matched case-insensitively.
+func (ctx *Context) FindProjectDir(name, version string, nocase bool) (string, error) {
+	for _, path := range ctx.ProjectsPath {
+		for _, suffix := range projectSuffixes(name, version) {
+			ctx.Debugf("checking [%s]...\n", filepath.Join(path, suffix))
+			dir, ok := lookupPath(path, suffix, nocase)
+			if ok {
+				ctx.Debugf("checking [%s]... [OK]\n", dir)
+				return dir, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf(
+		"lbx: no such project(name=%q, version=%q) in:\n%s",
+		name, version, fmtPaths(ctx.ProjectsPath),
+	)
+}
+
+// lookupPath returns the path to the directory name under dir.
+// If nocase is true, each element of name is matched case-insensitively.
+func lookupPath(dir, name string, nocase bool) (string, bool) {
+	path := filepath.Join(dir, name)
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return path, true
+	}
+	if !nocase {
+		return "", false
+	}
+
+	path = dir
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return "", false
+		}
+		match := ""
+		for _, fi := range entries {
+			if fi.IsDir() && strings.EqualFold(fi.Name(), elem) {
+				match = fi.Name()
+				break
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+		path = filepath.Join(path, match)
+	}
+	return path, true
+}
+
+// fmtPaths formats a list of paths, one per line.
+func fmtPaths(paths []string) string {
+	if len(paths) <= 0 {
+		return " - <empty search path>"
+	}
+	lines := make([]string, 0, len(paths))
+	for _, p := range paths {
+		lines = append(lines, " - "+p)
+	}
+	return strings.Join(lines, "\n")
+}
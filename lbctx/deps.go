@@ -0,0 +1,297 @@
+package lbctx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NodeKind is the kind of a node in a DependencyGraph
+type NodeKind int
+
+const (
+	ProjectNode NodeKind = iota // a Gaudi-based project
+	DataPkgNode                 // a data package
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case ProjectNode:
+		return "project"
+	case DataPkgNode:
+		return "data-package"
+	}
+	panic("unreachable")
+}
+
+// Node is a project or data package in a DependencyGraph
+type Node struct {
+	Kind    NodeKind
+	Name    string
+	Version string  // requested version (resolved version for data packages)
+	Dir     string  // installation directory. empty if it could not be found.
+	Deps    []*Node // direct dependencies
+
+	wants []string // versions of the direct dependencies requested by this node
+}
+
+func (n *Node) String() string {
+	return n.Name + " " + n.Version
+}
+
+// Request records the version of a dependency requested by a node
+type Request struct {
+	From    *Node
+	Version string
+}
+
+// Conflict describes a dependency requested with different versions
+type Conflict struct {
+	Kind     NodeKind
+	Name     string
+	Requests []Request
+}
+
+func (c Conflict) String() string {
+	reqs := make([]string, 0, len(c.Requests))
+	for _, req := range c.Requests {
+		reqs = append(reqs, fmt.Sprintf("%s (from %s)", req.Version, req.From))
+	}
+	return fmt.Sprintf("%s %s requested with different versions: %s",
+		c.Kind, c.Name, strings.Join(reqs, ", "),
+	)
+}
+
+// DependencyGraph is the graph of projects and data packages used by a project,
+// as described by the manifest.xml files of the installed projects.
+type DependencyGraph struct {
+	Root      *Node
+	Conflicts []Conflict
+
+	nodes map[string]*Node
+}
+
+// Dependencies builds the dependency graph of a project.
+// When a dependency is requested with different versions, the version
+// requested closest to the root project is used and a Conflict is recorded.
+func (ctx *Context) Dependencies(project, version, platform string) (*DependencyGraph, error) {
+	projdir, err := ctx.FindProject(project, version, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &Node{
+		Kind:    ProjectNode,
+		Name:    project,
+		Version: version,
+		Dir:     projdir,
+	}
+	g := &DependencyGraph{
+		Root:  root,
+		nodes: map[string]*Node{nodeKey(ProjectNode, project): root},
+	}
+
+	reqs := make(map[string][]Request)
+	keys := make([]string, 0)
+	request := func(from *Node, kind NodeKind, name, version string) (*Node, bool) {
+		key := nodeKey(kind, name)
+		if _, dup := reqs[key]; !dup {
+			keys = append(keys, key)
+		}
+		reqs[key] = append(reqs[key], Request{From: from, Version: version})
+		n, dup := g.nodes[key]
+		if !dup {
+			n = &Node{Kind: kind, Name: name, Version: version}
+			g.nodes[key] = n
+		}
+		from.Deps = append(from.Deps, n)
+		from.wants = append(from.wants, version)
+		return n, !dup
+	}
+
+	queue := []*Node{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node.Dir == "" {
+			continue
+		}
+
+		fname := filepath.Join(node.Dir, "manifest.xml")
+		if _, err := os.Stat(fname); err != nil {
+			continue
+		}
+		m, err := parseManifestFile(fname)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, proj := range m.UsedProjects {
+			dep, ok := request(node, ProjectNode, proj.Name, proj.Version)
+			if !ok {
+				continue
+			}
+			dir, err := ctx.FindProject(proj.Name, proj.Version, platform)
+			if err != nil {
+				ctx.Debugf("%v\n", err)
+				continue
+			}
+			dep.Dir = dir
+			queue = append(queue, dep)
+		}
+
+		for _, dpkg := range m.UsedDataPkgs {
+			vers := dpkg.Version
+			dir, err := ctx.FindDataPackage(dpkg.Name, dpkg.Version)
+			if err == nil {
+				vers = filepath.Base(dir)
+			}
+			dep, ok := request(node, DataPkgNode, dpkg.Name, vers)
+			if ok && err == nil {
+				dep.Dir = dir
+			}
+		}
+	}
+
+	for _, key := range keys {
+		versions := make(map[string]struct{})
+		for _, req := range reqs[key] {
+			versions[req.Version] = struct{}{}
+		}
+		if len(versions) < 2 {
+			continue
+		}
+		n := g.nodes[key]
+		g.Conflicts = append(g.Conflicts, Conflict{
+			Kind:     n.Kind,
+			Name:     n.Name,
+			Requests: reqs[key],
+		})
+	}
+
+	return g, nil
+}
+
+func nodeKey(kind NodeKind, name string) string {
+	return fmt.Sprintf("%d:%s", kind, name)
+}
+
+func parseManifestFile(fname string) (*Manifest, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ParseManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("lbx: error parsing [%s]: %v", fname, err)
+	}
+	return m, nil
+}
+
+// Sorted returns the nodes of the graph in topological order:
+// dependencies come before the nodes using them.
+func (g *DependencyGraph) Sorted() []*Node {
+	nodes := make([]*Node, 0, len(g.nodes))
+	seen := make(map[*Node]bool, len(g.nodes))
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, dep := range n.Deps {
+			visit(dep)
+		}
+		nodes = append(nodes, n)
+	}
+	visit(g.Root)
+	return nodes
+}
+
+// WriteTree writes the graph as an indented tree.
+// Nodes already printed are marked with (*) and not expanded again.
+func (g *DependencyGraph) WriteTree(w io.Writer) error {
+	seen := make(map[*Node]bool, len(g.nodes))
+	var write func(n *Node, want, indent string) error
+	write = func(n *Node, want, indent string) error {
+		line := indent + n.String()
+		if want != n.Version {
+			line += " (requested " + want + ")"
+		}
+		if n.Kind == DataPkgNode {
+			line += " [data]"
+		}
+		if n.Dir == "" {
+			line += " [not found]"
+		}
+		if seen[n] && len(n.Deps) > 0 {
+			_, err := fmt.Fprintf(w, "%s (*)\n", line)
+			return err
+		}
+		seen[n] = true
+		_, err := fmt.Fprintf(w, "%s\n", line)
+		if err != nil {
+			return err
+		}
+		for i, dep := range n.Deps {
+			err = write(dep, n.wants[i], indent+"  ")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return write(g.Root, g.Root.Version, "")
+}
+
+// WriteList writes the graph as a flat list in topological order.
+func (g *DependencyGraph) WriteList(w io.Writer) error {
+	for _, n := range g.Sorted() {
+		_, err := fmt.Fprintf(w, "%s %s %s\n", n.Kind, n.Name, n.Version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format.
+// Conflicting dependencies are highlighted in red.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	conflicts := make(map[string]struct{}, len(g.Conflicts))
+	for _, c := range g.Conflicts {
+		conflicts[nodeKey(c.Kind, c.Name)] = struct{}{}
+	}
+
+	_, err := fmt.Fprintf(w, "digraph %q {\n", g.Root.Name)
+	if err != nil {
+		return err
+	}
+	nodes := g.Sorted()
+	for _, n := range nodes {
+		attrs := []string{fmt.Sprintf("label=%q", n.Name+"\n"+n.Version)}
+		if n.Kind == DataPkgNode {
+			attrs = append(attrs, "shape=box")
+		}
+		if _, ok := conflicts[nodeKey(n.Kind, n.Name)]; ok {
+			attrs = append(attrs, "color=red")
+		}
+		_, err = fmt.Fprintf(w, "\t%q [%s];\n", n.Name, strings.Join(attrs, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	for _, n := range nodes {
+		for _, dep := range n.Deps {
+			_, err = fmt.Fprintf(w, "\t%q -> %q;\n", n.Name, dep.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = fmt.Fprintf(w, "}\n")
+	return err
+}
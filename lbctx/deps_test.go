@@ -0,0 +1,127 @@
+package lbctx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gonuts/logger"
+)
+
+func TestDependencies(t *testing.T) {
+	const platform = "x86_64-slc6-gcc48-opt"
+
+	top, err := ioutil.TempDir("", "lbctx-deps-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	type use struct {
+		name, vers string
+	}
+	mkproj := func(name, vers string, projs []use, dpkgs []use) {
+		dir := filepath.Join(top, name+"_"+vers, "InstallArea", platform)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "<manifest>\n<project name=%q version=%q />\n", name, vers)
+		fmt.Fprintf(&buf, "<used_projects>\n")
+		for _, p := range projs {
+			fmt.Fprintf(&buf, "<project name=%q version=%q />\n", p.name, p.vers)
+		}
+		fmt.Fprintf(&buf, "</used_projects>\n<used_data_pkgs>\n")
+		for _, p := range dpkgs {
+			fmt.Fprintf(&buf, "<package name=%q version=%q />\n", p.name, p.vers)
+		}
+		fmt.Fprintf(&buf, "</used_data_pkgs>\n</manifest>\n")
+		err = ioutil.WriteFile(filepath.Join(dir, "manifest.xml"), buf.Bytes(), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	mkproj("Gaudi", "v25r2", nil, nil)
+	mkproj("Gaudi", "v25r1", nil, nil)
+	mkproj("LHCb", "v36r1", []use{{"Gaudi", "v25r2"}}, []use{{"FieldMap", "v5r*"}})
+	mkproj("Lbcom", "v15r1", []use{{"LHCb", "v36r1"}}, nil)
+	mkproj("Rec", "v16r1", []use{{"Gaudi", "v25r1"}}, nil)
+	mkproj("DaVinci", "v34r1", []use{{"Lbcom", "v15r1"}, {"Rec", "v16r1"}}, []use{{"AppConfig", "v3r*"}})
+
+	for _, dir := range []string{"DBASE/FieldMap/v5r7", "DBASE/FieldMap/v5r10", "DBASE/AppConfig/v3r200"} {
+		err = os.MkdirAll(filepath.Join(top, dir), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	ctx := &Context{
+		msg:          logger.New("test"),
+		ProjectsPath: []string{top},
+	}
+
+	g, err := ctx.Dependencies("DaVinci", "v34r1", platform)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	sorted := make([]string, 0)
+	for _, n := range g.Sorted() {
+		sorted = append(sorted, n.String())
+	}
+	want := []string{
+		"Gaudi v25r1", "FieldMap v5r10", "LHCb v36r1", "Lbcom v15r1",
+		"Rec v16r1", "AppConfig v3r200", "DaVinci v34r1",
+	}
+	if !reflect.DeepEqual(sorted, want) {
+		t.Fatalf("expected sorted nodes:\n%v\ngot:\n%v", want, sorted)
+	}
+
+	if len(g.Conflicts) != 1 {
+		t.Fatalf("expected 1 conflict. got=%d (%v)", len(g.Conflicts), g.Conflicts)
+	}
+	c := g.Conflicts[0]
+	if c.Name != "Gaudi" || len(c.Requests) != 2 {
+		t.Fatalf("invalid conflict: %v", c)
+	}
+	// Rec is closer to DaVinci than LHCb: its request wins.
+	if c.Requests[0].Version != "v25r1" || c.Requests[1].Version != "v25r2" {
+		t.Fatalf("invalid conflict: %v", c)
+	}
+
+	var buf bytes.Buffer
+	err = g.WriteTree(&buf)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	tree := strings.Join([]string{
+		"DaVinci v34r1",
+		"  Lbcom v15r1",
+		"    LHCb v36r1",
+		"      Gaudi v25r1 (requested v25r2)",
+		"      FieldMap v5r10 [data]",
+		"  Rec v16r1",
+		"    Gaudi v25r1",
+		"  AppConfig v3r200 [data]",
+		"",
+	}, "\n")
+	if buf.String() != tree {
+		t.Fatalf("expected tree:\n%s\ngot:\n%s", tree, buf.String())
+	}
+
+	buf.Reset()
+	err = g.WriteDOT(&buf)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"Rec" -> "Gaudi";`) {
+		t.Fatalf("missing edge in DOT output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,143 @@
+package lbctx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lhcb-org/lbx/lbctx/vcs"
+)
+
+// PackageIndex lists the packages held by a repository.
+// Packages should give up when ctx is done.
+type PackageIndex interface {
+	Packages(ctx context.Context, repo *RepoInfo) (Packages, error)
+}
+
+// indices holds the package index of each vcs type.
+var indices = map[string]PackageIndex{
+	"svn": svnIndex{},
+	"git": gitIndex{},
+}
+
+// RegisterIndex registers the package index used for repositories of the
+// given vcs type (hg, git, svn or bzr), replacing any previous one.
+// RegisterIndex should be called before any package is listed.
+func RegisterIndex(vcs string, idx PackageIndex) {
+	indices[vcs] = idx
+}
+
+// svnIndex lists the packages of an svn repository from its 'packages'
+// property. Each line of the property holds a package name and its project.
+type svnIndex struct{}
+
+func (svnIndex) Packages(ctx context.Context, repo *RepoInfo) (Packages, error) {
+	var err error
+
+	// FIXME: first check 'propget version' >= 2.0
+
+	// assume propget-version >= 2.0
+	bout, err := vcs.RunContext(ctx, repo.Cmd, "propget packages {repo}", "repo", repo.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := make(Packages)
+	scan := bufio.NewScanner(bytes.NewReader(bout))
+	for scan.Scan() {
+		bline := bytes.Trim(scan.Bytes(), " \n")
+		if bytes.HasPrefix(bline, []byte("#")) {
+			continue
+		}
+		tokens := strings.Fields(string(bline))
+		if len(tokens) <= 0 {
+			continue
+		}
+		project := ""
+		pkgname := tokens[0]
+		if len(tokens) > 1 {
+			project = tokens[1]
+		}
+		pkgs[pkgname] = Package{
+			Name:    pkgname,
+			Project: project,
+			Repo:    repo.Repo,
+		}
+	}
+	err = scan.Err()
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, err
+}
+
+// gitIndex lists the packages of a git repository by scanning the tree of
+// its default branch for CMakeLists.txt and cmt/requirements files.
+// A git repository holds a single project, named after the last component
+// of the repository URL.
+type gitIndex struct{}
+
+func (gitIndex) Packages(ctx context.Context, repo *RepoInfo) (Packages, error) {
+	tmpdir, err := ioutil.TempDir("", "lbx-pkg-index-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// a shallow bare clone is enough to list the tree.
+	_, err = vcs.RunContext(ctx, repo.Cmd, "clone -q --bare --depth 1 {repo} {dir}", "repo", repo.Repo, "dir", tmpdir)
+	if err != nil {
+		return nil, err
+	}
+
+	bout, err := vcs.RunContext(ctx, repo.Cmd, "--git-dir {dir} ls-tree -r --name-only HEAD", "dir", tmpdir)
+	if err != nil {
+		return nil, err
+	}
+
+	project := strings.TrimSuffix(path.Base(strings.TrimSuffix(repo.Repo, "/")), ".git")
+	pkgs := make(Packages)
+	for _, name := range gitPackageNames(strings.Split(string(bout), "\n")) {
+		pkgs[name] = Package{
+			Name:    name,
+			Project: project,
+			Repo:    repo.Repo,
+		}
+	}
+	return pkgs, nil
+}
+
+// gitPackageNames returns the names of the packages found in a list of files.
+// Directories below a package (e.g. with their own CMakeLists.txt) are not
+// packages. The top-level CMakeLists.txt is the one of the project.
+func gitPackageNames(files []string) []string {
+	var dirs []string
+	for _, fname := range files {
+		switch {
+		case strings.HasSuffix(fname, "/cmt/requirements"):
+			dirs = append(dirs, strings.TrimSuffix(fname, "/cmt/requirements"))
+		case strings.HasSuffix(fname, "/CMakeLists.txt"):
+			dirs = append(dirs, strings.TrimSuffix(fname, "/CMakeLists.txt"))
+		}
+	}
+	sort.Strings(dirs)
+
+	var names []string
+	seen := make(map[string]struct{})
+loop:
+	for _, dir := range dirs {
+		for p := dir; p != "." && p != "/"; p = path.Dir(p) {
+			if _, dup := seen[p]; dup {
+				continue loop
+			}
+		}
+		seen[dir] = struct{}{}
+		names = append(names, dir)
+	}
+	return names
+}
@@ -0,0 +1,89 @@
+package lbctx
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lhcb-org/lbx/lbctx/vcs"
+)
+
+func TestGitPackageNames(t *testing.T) {
+	names := gitPackageNames([]string{
+		"CMakeLists.txt",
+		"Hat/A/CMakeLists.txt",
+		"Hat/A/src/CMakeLists.txt",
+		"Hat/A-b/CMakeLists.txt",
+		"Hat/A-b/cmt/requirements",
+		"Hat/B/cmt/requirements",
+		"Hat/B/src/B.cpp",
+		"Other/CMakeLists.txt",
+		"README.md",
+	})
+	want := []string{"Hat/A", "Hat/A-b", "Hat/B", "Other"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %v. got=%v", want, names)
+	}
+}
+
+func TestGitIndex(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-index-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	repo := filepath.Join(top, "MyProject")
+	for _, fname := range []string{
+		"CMakeLists.txt",
+		"Hat/A/CMakeLists.txt",
+		"Hat/B/cmt/requirements",
+	} {
+		fname = filepath.Join(repo, fname)
+		err = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		err = ioutil.WriteFile(fname, []byte("\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=lbx", "-c", "user.email=lbx@example.com", "commit", "-q", "-m", "initial import"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, string(out))
+		}
+	}
+
+	info := RepoInfo{
+		Name: "test",
+		VCS:  "git",
+		Cmd:  vcs.Git,
+		Repo: "file://" + repo,
+	}
+	pkgs := info.ListPackages("Hat/")
+	if len(pkgs) != 2 {
+		t.Fatalf("expected 2 packages. got=%v", pkgs)
+	}
+	for _, pkg := range pkgs {
+		if pkg.Project != "MyProject" || pkg.Repo != info.Repo {
+			t.Fatalf("invalid package: %+v", pkg)
+		}
+		if pkg.Name != "Hat/A" && pkg.Name != "Hat/B" {
+			t.Fatalf("unexpected package: %+v", pkg)
+		}
+	}
+}
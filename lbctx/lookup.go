@@ -2,51 +2,21 @@ package lbctx
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
 )
 
 type datapkgType struct {
 	Path    string
-	Version string
-}
-
-func (i datapkgType) less(j datapkgType) bool {
-	ii := i.version()
-	jj := j.version()
-	nn := len(ii)
-	if nn > len(jj) {
-		nn = len(jj)
-	}
-	for idx := 0; idx < nn; idx++ {
-		if ii[idx] != jj[idx] {
-			return ii[idx] < jj[idx]
-		}
-	}
-	return len(ii) < len(jj)
-}
-
-func (dp datapkgType) version() []int {
-	re := regexp.MustCompile(`\d+`)
-	slice := re.Split(dp.Version, -1)
-	v := make([]int, 0, len(slice))
-	for _, str := range slice {
-		vv, err := strconv.Atoi(str)
-		if err != nil {
-			panic(fmt.Errorf("lbx.datapkgType.version: %v (string=%q)", err, str))
-		}
-		v = append(v, vv)
-	}
-	return v
+	Version Version
 }
 
 type datapkgTypes []datapkgType
 
 func (p datapkgTypes) Len() int           { return len(p) }
-func (p datapkgTypes) Less(i, j int) bool { return p[i].Version < p[j].Version }
+func (p datapkgTypes) Less(i, j int) bool { return p[i].Version.Less(p[j].Version) }
 func (p datapkgTypes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 // FindDataPackage finds a data package among the Context.ProjectsPath,
@@ -66,12 +36,13 @@ func (ctx *Context) FindDataPackage(name, version string) (string, error) {
 				return "", err
 			}
 			for _, v := range list {
+				v = filepath.Base(v)
 				if v == version {
 					// stop searching if we've found an exact match
 					return filepath.Join(p, v), nil
 				}
 				if ok, err := filepath.Match(version, v); ok && err == nil {
-					versions = append(versions, datapkgType{Path: p, Version: v})
+					versions = append(versions, datapkgType{Path: p, Version: ParseVersion(v)})
 				}
 			}
 		}
@@ -83,7 +54,40 @@ func (ctx *Context) FindDataPackage(name, version string) (string, error) {
 		)
 	}
 
-	sort.Reverse(datapkgTypes(versions))
+	sort.Sort(sort.Reverse(datapkgTypes(versions)))
 	sel := versions[0]
-	return filepath.Join(sel.Path, sel.Version), nil
+	return filepath.Join(sel.Path, sel.Version.String()), nil
+}
+
+// FindPackageDir finds the directory of package pkg inside the project
+// directory projdir.
+// The source tree of the project is searched first, then its InstallArea.
+// pkg may be given with its hat (e.g. "Phys/DaVinciKernel") or without.
+// If nocase is true, the package name is matched case-insensitively.
+func (ctx *Context) FindPackageDir(projdir, pkg string, nocase bool) (string, error) {
+	roots := []string{projdir, filepath.Join(projdir, "InstallArea")}
+	for _, root := range roots {
+		ctx.Debugf("checking [%s]...\n", filepath.Join(root, pkg))
+		if dir, ok := lookupPath(root, pkg, nocase); ok {
+			ctx.Debugf("checking [%s]... [OK]\n", dir)
+			return dir, nil
+		}
+
+		// the package may have been given without its hat.
+		hats, err := ioutil.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, hat := range hats {
+			if !hat.IsDir() || hat.Name() == "InstallArea" {
+				continue
+			}
+			if dir, ok := lookupPath(filepath.Join(root, hat.Name()), pkg, nocase); ok {
+				ctx.Debugf("checking [%s]... [OK]\n", dir)
+				return dir, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("lbx: no such package %q in project [%s]", pkg, projdir)
 }
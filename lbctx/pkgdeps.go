@@ -0,0 +1,156 @@
+package lbctx
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PackageDeps maps the packages of a project to the packages of the same
+// project they directly depend on.
+type PackageDeps map[string][]string
+
+// ProjectPackageDeps returns the dependencies between the packages of the
+// project sources in projdir, from the gaudi_depends_on_subdirs declarations
+// of their CMakeLists.txt and the 'use' statements of their cmt/requirements.
+// Dependencies on packages of other projects are dropped.
+func ProjectPackageDeps(projdir string) (PackageDeps, error) {
+	pkgs, err := WorkAreaPackages(projdir)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make(PackageDeps, len(pkgs))
+	for _, pkg := range pkgs {
+		deps[filepath.ToSlash(pkg)] = nil
+	}
+
+	for _, pkg := range pkgs {
+		uses, err := readPackageUses(filepath.Join(projdir, pkg))
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.ToSlash(pkg)
+		set := make(map[string]struct{})
+		for _, use := range uses {
+			dep, ok := deps.Lookup(use)
+			if !ok || dep == name {
+				continue
+			}
+			if _, dup := set[dep]; dup {
+				continue
+			}
+			set[dep] = struct{}{}
+			deps[name] = append(deps[name], dep)
+		}
+		sort.Strings(deps[name])
+	}
+	return deps, nil
+}
+
+// Lookup returns the full name of package pkg, which may be given with or
+// without its hat.
+func (deps PackageDeps) Lookup(pkg string) (string, bool) {
+	if _, ok := deps[pkg]; ok {
+		return pkg, true
+	}
+	match := ""
+	for name := range deps {
+		if path.Base(name) != pkg {
+			continue
+		}
+		if match != "" {
+			// ambiguous
+			return "", false
+		}
+		match = name
+	}
+	return match, match != ""
+}
+
+// Closure returns pkg with the packages it (transitively) depends on or, if
+// users is true, with the packages (transitively) depending on it.
+func (deps PackageDeps) Closure(pkg string, users bool) []string {
+	edges := map[string][]string(deps)
+	if users {
+		edges = make(map[string][]string, len(deps))
+		for name, ds := range deps {
+			for _, dep := range ds {
+				edges[dep] = append(edges[dep], name)
+			}
+		}
+	}
+
+	seen := map[string]bool{pkg: true}
+	queue := []string{pkg}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range edges[cur] {
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			queue = append(queue, next)
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+var dependsOnRe = regexp.MustCompile(`(?s)gaudi_depends_on_subdirs\s*\(([^)]*)\)`)
+
+// readPackageUses returns the names of the packages used by the package in
+// pkgdir, as given in its CMakeLists.txt or cmt/requirements.
+func readPackageUses(pkgdir string) ([]string, error) {
+	var uses []string
+
+	buf, err := ioutil.ReadFile(filepath.Join(pkgdir, "CMakeLists.txt"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, m := range dependsOnRe.FindAllSubmatch(stripCMakeComments(buf), -1) {
+		uses = append(uses, strings.Fields(string(m[1]))...)
+	}
+
+	buf, err = ioutil.ReadFile(filepath.Join(pkgdir, "cmt", "requirements"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// use <name> <version> [<hat>] [-no_auto_imports]...
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		toks := strings.Fields(line)
+		if len(toks) < 2 || toks[0] != "use" {
+			continue
+		}
+		name := toks[1]
+		if len(toks) > 3 && !strings.HasPrefix(toks[3], "-") {
+			name = toks[3] + "/" + name
+		}
+		uses = append(uses, name)
+	}
+	return uses, nil
+}
+
+// stripCMakeComments removes the # comments from a CMake file.
+func stripCMakeComments(buf []byte) []byte {
+	lines := strings.Split(string(buf), "\n")
+	for i, line := range lines {
+		if j := strings.Index(line, "#"); j >= 0 {
+			lines[i] = line[:j]
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
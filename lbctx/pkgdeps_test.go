@@ -0,0 +1,77 @@
+package lbctx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProjectPackageDeps(t *testing.T) {
+	top, err := ioutil.TempDir("", "lbctx-pkgdeps-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	for fname, content := range map[string]string{
+		"CMakeLists.txt": "gaudi_project(Phys v18r1 USE Rec v14r1)\n",
+		"Phys/DaVinciKernel/CMakeLists.txt": `gaudi_subdir(DaVinciKernel v10r2)
+
+gaudi_depends_on_subdirs(GaudiKernel
+                         # Phys/Commented
+                         Phys/LoKiPhys)
+`,
+		"Phys/LoKiPhys/cmt/requirements": "package LoKiPhys\nversion v11r3\n\nuse GaudiKernel v*\nuse LoKiCore v* Phys -no_auto_imports\n",
+		"Phys/LoKiCore/CMakeLists.txt":   "gaudi_subdir(LoKiCore v11r0)\n",
+		"Phys/DaVinciTools/CMakeLists.txt": `gaudi_subdir(DaVinciTools v17r0)
+gaudi_depends_on_subdirs(DaVinciKernel)
+`,
+		"Phys/Unrelated/CMakeLists.txt": "gaudi_subdir(Unrelated v1r0)\n",
+	} {
+		fname = filepath.Join(top, fname)
+		err = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		err = ioutil.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	deps, err := ProjectPackageDeps(top)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	want := PackageDeps{
+		"Phys/DaVinciKernel": {"Phys/LoKiPhys"},
+		"Phys/DaVinciTools":  {"Phys/DaVinciKernel"},
+		"Phys/LoKiCore":      nil,
+		"Phys/LoKiPhys":      {"Phys/LoKiCore"},
+		"Phys/Unrelated":     nil,
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Fatalf("invalid dependencies:\nexp=%v\ngot=%v", want, deps)
+	}
+
+	pkg, ok := deps.Lookup("LoKiPhys")
+	if !ok || pkg != "Phys/LoKiPhys" {
+		t.Fatalf("expected to find Phys/LoKiPhys. got=%q", pkg)
+	}
+
+	for _, table := range []struct {
+		users bool
+		want  []string
+	}{
+		{false, []string{"Phys/LoKiCore", "Phys/LoKiPhys"}},
+		{true, []string{"Phys/DaVinciKernel", "Phys/DaVinciTools", "Phys/LoKiPhys"}},
+	} {
+		got := deps.Closure(pkg, table.users)
+		if !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("users=%v: expected %v. got=%v", table.users, table.want, got)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package lbctx
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PackageVersion returns the version of package pkg used by the project
+// name/version.
+// The package is located with LocatePackage.
+// The version is read from the cmt/version.cmt, cmt/requirements or
+// CMakeLists.txt file of the package.
+func (ctx *Context) PackageVersion(name, version, platform, pkg string) (string, error) {
+	_, pkgdir, err := ctx.LocatePackage(name, version, platform, pkg)
+	if err != nil {
+		return "", err
+	}
+	return readPackageVersion(pkgdir)
+}
+
+// LocatePackage returns the directory of the project holding package pkg and
+// the directory of the package, for the project release name/version.
+// The package is searched for in the project, then in the projects it uses,
+// as listed by the manifest.xml of the project for the given platform or by
+// its cmt/project.cmt file.
+func (ctx *Context) LocatePackage(name, version, platform, pkg string) (string, string, error) {
+	type project struct {
+		name    string
+		version string
+	}
+
+	queue := []project{{name, version}}
+	seen := make(map[project]bool)
+	for len(queue) > 0 {
+		proj := queue[0]
+		queue = queue[1:]
+		if seen[proj] {
+			continue
+		}
+		seen[proj] = true
+
+		projdir, err := ctx.FindProjectDir(proj.name, proj.version, false)
+		if err != nil {
+			if len(seen) == 1 {
+				return "", "", err
+			}
+			ctx.Debugf("skipping project %s %s: %v\n", proj.name, proj.version, err)
+			continue
+		}
+
+		if pkgdir, err := ctx.FindPackageDir(projdir, pkg, false); err == nil {
+			ctx.Debugf("package [%s] from [%s %s]\n", pkg, proj.name, proj.version)
+			return projdir, pkgdir, nil
+		}
+
+		used, err := ctx.usedProjects(projdir, proj.name, proj.version, platform)
+		if err != nil {
+			return "", "", err
+		}
+		for _, p := range used {
+			queue = append(queue, project{p[0], p[1]})
+		}
+	}
+
+	return "", "", fmt.Errorf("lbx: no package %q in project %s %s or the projects it uses", pkg, name, version)
+}
+
+// usedProjects returns the (name, version) pairs of the projects used by the
+// project name/version installed in projdir.
+func (ctx *Context) usedProjects(projdir, name, version, platform string) ([][2]string, error) {
+	var used [][2]string
+
+	if bindir, err := ctx.FindProject(name, version, platform); err == nil {
+		fname := filepath.Join(bindir, "manifest.xml")
+		if _, err := os.Stat(fname); err == nil {
+			m, err := parseManifestFile(fname)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range m.UsedProjects {
+				used = append(used, [2]string{p.Name, p.Version})
+			}
+			return used, nil
+		}
+	}
+
+	fname := filepath.Join(projdir, "cmt", "project.cmt")
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	// use <PROJECT> <PROJECT>_<version>
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		toks := strings.Fields(scan.Text())
+		if len(toks) < 3 || toks[0] != "use" {
+			continue
+		}
+		proj := FixProjectCase(toks[1])
+		vers := toks[2]
+		if i := strings.Index(vers, "_"); i >= 0 {
+			vers = vers[i+1:]
+		}
+		used = append(used, [2]string{proj, vers})
+	}
+	return used, scan.Err()
+}
+
+var gaudiSubdirRe = regexp.MustCompile(`gaudi_subdir\s*\(\s*\S+\s+([^\s)]+)\s*\)`)
+
+// readPackageVersion returns the version of the package in pkgdir.
+func readPackageVersion(pkgdir string) (string, error) {
+	if buf, err := ioutil.ReadFile(filepath.Join(pkgdir, "cmt", "version.cmt")); err == nil {
+		if vers := strings.TrimSpace(string(buf)); vers != "" {
+			return vers, nil
+		}
+	}
+
+	if buf, err := ioutil.ReadFile(filepath.Join(pkgdir, "cmt", "requirements")); err == nil {
+		for _, line := range strings.Split(string(buf), "\n") {
+			toks := strings.Fields(line)
+			if len(toks) >= 2 && toks[0] == "version" {
+				return toks[1], nil
+			}
+		}
+	}
+
+	if buf, err := ioutil.ReadFile(filepath.Join(pkgdir, "CMakeLists.txt")); err == nil {
+		if m := gaudiSubdirRe.FindSubmatch(buf); m != nil {
+			return string(m[1]), nil
+		}
+	}
+
+	return "", fmt.Errorf("lbx: could not determine the version of the package in [%s]", pkgdir)
+}
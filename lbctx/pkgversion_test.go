@@ -0,0 +1,70 @@
+package lbctx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gonuts/logger"
+)
+
+func TestPackageVersion(t *testing.T) {
+	top, err := ioutil.TempDir("", "lbctx-pkgversion-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	for fname, content := range map[string]string{
+		"DAVINCI/DAVINCI_v34r1/cmt/project.cmt":                             "project DAVINCI_v34r1\n\nuse ANALYSIS ANALYSIS_v14r1\nuse PHYS PHYS_v18r1\n",
+		"DAVINCI/DAVINCI_v34r1/Phys/DaVinci/CMakeLists.txt":                 "gaudi_subdir(DaVinci v34r1)\n",
+		"ANALYSIS/ANALYSIS_v14r1/cmt/project.cmt":                           "project ANALYSIS_v14r1\n\nuse PHYS PHYS_v18r1\n",
+		"PHYS/PHYS_v18r1/Phys/DaVinciKernel/cmt/requirements":               "package DaVinciKernel\nversion           v10r2\n",
+		"PHYS/PHYS_v18r1/Phys/LoKiPhys/cmt/version.cmt":                     "v11r3\n",
+		"PHYS/PHYS_v18r1/Phys/LoKiPhys/cmt/requirements":                    "package LoKiPhys\nversion v0r0\n",
+		"PHYS/PHYS_v18r1/Phys/NoVersion/src/NoVersion.cpp":                  "\n",
+		"PHYS/PHYS_v18r1/InstallArea/x86_64-slc6-gcc48-opt/python/dummy.py": "\n",
+	} {
+		fname = filepath.Join(top, fname)
+		err = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		err = ioutil.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	ctx := &Context{
+		msg:          logger.New("test"),
+		ProjectsPath: []string{top},
+	}
+
+	const platform = "x86_64-slc6-gcc48-opt"
+	for _, table := range []struct {
+		pkg  string
+		want string
+	}{
+		{"Phys/DaVinci", "v34r1"},
+		{"Phys/DaVinciKernel", "v10r2"},
+		{"DaVinciKernel", "v10r2"},
+		{"Phys/LoKiPhys", "v11r3"},
+	} {
+		vers, err := ctx.PackageVersion("DaVinci", "v34r1", platform, table.pkg)
+		if err != nil {
+			t.Fatalf("%s: error: %v", table.pkg, err)
+		}
+		if vers != table.want {
+			t.Fatalf("%s: expected %q. got=%q", table.pkg, table.want, vers)
+		}
+	}
+
+	for _, pkg := range []string{"Phys/NoVersion", "Phys/NoSuchPackage"} {
+		_, err = ctx.PackageVersion("DaVinci", "v34r1", platform, pkg)
+		if err == nil {
+			t.Fatalf("%s: expected an error", pkg)
+		}
+	}
+}
@@ -1,59 +1,150 @@
 package lbctx
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/gonuts/toml"
 	"github.com/lhcb-org/lbx/lbctx/vcs"
 )
 
-// Repos is the database of known repositories
-var Repos = RepoDb{
-	"gaudi": RepoInfos{
-		{
-			Cmd:  vcs.Svn,
-			Repo: "svn+ssh://svn.cern.ch/reps/gaudi",
-		},
-		{
-			Cmd:  vcs.Svn,
-			Repo: "http://svn.cern.ch/guest/gaudi",
+// DefaultRepos is the built-in database of repositories.
+// It is extended (or overridden) by the files returned by RepoConfigFiles.
+var DefaultRepos = []RepoConfig{
+	{
+		Name: "gaudi",
+		VCS:  "svn",
+		URLs: []string{
+			"svn+ssh://{user}@svn.cern.ch/reps/gaudi",
+			"http://svn.cern.ch/guest/gaudi",
 		},
 	},
-
-	"lbsvn": RepoInfos{
-		{
-			Cmd:  vcs.Svn,
-			Repo: "svn+ssh://svn.cern.ch/reps/lhcb",
-		},
-		{
-			Cmd:  vcs.Svn,
-			Repo: "http://svn.cern.ch/guest/lhcb",
+	{
+		Name: "lbsvn",
+		VCS:  "svn",
+		URLs: []string{
+			"svn+ssh://{user}@svn.cern.ch/reps/lhcb",
+			"http://svn.cern.ch/guest/lhcb",
 		},
 	},
-
-	"dirac": RepoInfos{
-		{
-			Cmd:  vcs.Svn,
-			Repo: "svn+ssh://svn.cern.ch/reps/dirac",
-		},
-		{
-			Cmd:  vcs.Svn,
-			Repo: "http://svn.cern.ch/guest/dirac",
+	{
+		Name: "dirac",
+		VCS:  "svn",
+		URLs: []string{
+			"svn+ssh://{user}@svn.cern.ch/reps/dirac",
+			"http://svn.cern.ch/guest/dirac",
 		},
 	},
-
-	"lhcbint": RepoInfos{
-		{
-			Cmd:  vcs.Svn,
-			Repo: "svn+ssh://svn.cern.ch/reps/lhcbint",
+	{
+		Name: "lhcbint",
+		VCS:  "svn",
+		URLs: []string{
+			"svn+ssh://{user}@svn.cern.ch/reps/lhcbint",
 		},
 	},
 }
 
+// Repos is the built-in database of known repositories.
+//
+// Deprecated: Repos does not hold the repositories of the configuration
+// files. Use LoadRepositories.
+var Repos = defaultRepoDb()
+
+// defaultRepoDb returns the built-in repositories, for any user and protocol.
+func defaultRepoDb() RepoDb {
+	repos, err := makeRepoDb(DefaultRepos, "", "")
+	if err != nil {
+		panic(err)
+	}
+	return repos
+}
+
+// RepoConfig describes an entry of the repositories database.
+//
+// In a TOML file, entries are given as:
+//
+//	[[repository]]
+//	name      = "gitlab"
+//	vcs       = "git"
+//	urls      = ["{protocol}://{user}@gitlab.cern.ch/lhcb"]
+//	protocols = ["https", "ssh"]
+//	priority  = 10
+//
+// {user} is replaced with the user name. "{user}@" is dropped when no user
+// name is given.
+// URL templates with {protocol} are expanded once per protocol of Protocols.
+type RepoConfig struct {
+	Name      string   `toml:"name"`
+	VCS       string   `toml:"vcs"`       // hg, git, svn or bzr
+	URLs      []string `toml:"urls"`      // URL templates, in order of preference
+	Protocols []string `toml:"protocols"` // values for {protocol}
+	Priority  int      `toml:"priority"`  // packages from higher priority repositories win
+	Disabled  bool     `toml:"disabled"`  // removes a repository defined by a previous file
+}
+
+// RepoConfigFiles returns the files the repositories database is read from,
+// by increasing order of precedence: the system-wide file, the user file and
+// the file of the current workarea.
+func RepoConfigFiles() []string {
+	fnames := []string{
+		filepath.Join(string(os.PathSeparator), "etc", "lbx", "repositories.toml"),
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		fnames = append(fnames, filepath.Join(home, ".lbx", "repositories.toml"))
+	}
+	fnames = append(fnames, filepath.Join(".lbx", "repositories.toml"))
+	return fnames
+}
+
+// LoadRepoConfigs returns the built-in repositories updated with the entries
+// of the given TOML files, in order. Missing files are ignored.
+// Entries with the same name as a previous one replace it.
+func LoadRepoConfigs(fnames ...string) ([]RepoConfig, error) {
+	cfgs := append([]RepoConfig{}, DefaultRepos...)
+	for _, fname := range fnames {
+		if _, err := os.Stat(fname); err != nil {
+			continue
+		}
+		var db struct {
+			Repository []RepoConfig `toml:"repository"`
+		}
+		_, err := toml.DecodeFile(fname, &db)
+		if err != nil {
+			return nil, fmt.Errorf("lbctx: could not read repositories from [%s]: %v", fname, err)
+		}
+		cfgs = mergeRepoConfigs(cfgs, db.Repository)
+	}
+	return cfgs, nil
+}
+
+// mergeRepoConfigs returns the entries of base updated with the ones of over.
+func mergeRepoConfigs(base, over []RepoConfig) []RepoConfig {
+	cfgs := append([]RepoConfig{}, base...)
+	for _, cfg := range over {
+		replaced := false
+		for i := range cfgs {
+			if cfgs[i].Name == cfg.Name {
+				cfgs[i] = cfg
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	return cfgs
+}
+
 type RepoInfo struct {
-	Cmd  *vcs.Cmd
-	Repo string
+	Name     string
+	VCS      string // hg, git, svn or bzr
+	Cmd      *vcs.Cmd
+	Repo     string
+	Priority int
 
 	pkgs Packages
 }
@@ -61,33 +152,129 @@ type RepoInfo struct {
 type RepoInfos []RepoInfo
 type RepoDb map[string]RepoInfos
 
-// Repositories returns a map of named-repositories
+// Repositories returns a map of named-repositories, like LoadRepositories.
+// If the configuration files can not be read, only the built-in repositories
+// are returned: use LoadRepositories to report the problem.
 func Repositories(user, protocol string) RepoDb {
-	repos := make(RepoDb, len(Repos))
-	for k := range Repos {
-		repos[k] = append([]RepoInfo{}, Repos[k]...)
+	repos, err := LoadRepositories(user, protocol)
+	if err != nil {
+		repos, _ = makeRepoDb(DefaultRepos, user, protocol)
 	}
-	if user != "" {
+	return repos
+}
 
+// LoadRepositories returns a map of named-repositories, loaded from the
+// built-in database and the files of RepoConfigFiles.
+// If protocol is not empty, only the URLs using that protocol are kept.
+// user is substituted in the URLs.
+func LoadRepositories(user, protocol string) (RepoDb, error) {
+	cfgs, err := LoadRepoConfigs(RepoConfigFiles()...)
+	if err != nil {
+		return nil, err
 	}
-	return repos
+	return makeRepoDb(cfgs, user, protocol)
+}
+
+// makeRepoDb expands the URL templates of cfgs for user and protocol.
+func makeRepoDb(cfgs []RepoConfig, user, protocol string) (RepoDb, error) {
+	repos := make(RepoDb, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.Disabled {
+			continue
+		}
+		cmd := vcs.ByCmd(cfg.VCS)
+		if cmd == nil {
+			return nil, fmt.Errorf("lbctx: repository %q has an unknown vcs type %q", cfg.Name, cfg.VCS)
+		}
+		var infos RepoInfos
+		for _, tmpl := range cfg.URLs {
+			protos := []string{""}
+			if strings.Contains(tmpl, "{protocol}") {
+				protos = cfg.Protocols
+			}
+			for _, proto := range protos {
+				url := expandRepoURL(tmpl, user, proto)
+				if protocol != "" && !matchProtocol(url, protocol) {
+					continue
+				}
+				infos = append(infos, RepoInfo{
+					Name:     cfg.Name,
+					VCS:      cfg.VCS,
+					Cmd:      cmd,
+					Repo:     url,
+					Priority: cfg.Priority,
+				})
+			}
+		}
+		if len(infos) > 0 {
+			repos[cfg.Name] = infos
+		}
+	}
+	return repos, nil
+}
+
+// expandRepoURL substitutes user and protocol in the URL template tmpl.
+func expandRepoURL(tmpl, user, protocol string) string {
+	url := tmpl
+	if user == "" {
+		url = strings.Replace(url, "{user}@", "", -1)
+	}
+	url = strings.Replace(url, "{user}", user, -1)
+	url = strings.Replace(url, "{protocol}", protocol, -1)
+	return url
+}
+
+// matchProtocol returns whether the scheme of url is protocol, or a
+// composite scheme using it (e.g. svn+ssh for ssh.)
+func matchProtocol(url, protocol string) bool {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return false
+	}
+	for _, p := range strings.Split(url[:i], "+") {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
 }
 
 func (repos *RepoInfos) ListPackages(hat string) []Package {
+	pkgs, _ := repos.ListPackagesContext(context.Background(), hat)
+	return pkgs
+}
+
+// ListPackagesContext is like ListPackages but the repositories are not
+// queried any more once ctx is done.
+// The URLs of the repository are tried in turn: the error of the last one is
+// returned if none could be listed.
+func (repos *RepoInfos) ListPackagesContext(ctx context.Context, hat string) ([]Package, error) {
+	err := fmt.Errorf("lbctx: no repository URL to list")
 	for _, repo := range *repos {
-		pkgs := repo.ListPackages(hat)
-		if pkgs != nil {
-			return pkgs
+		var pkgs []Package
+		pkgs, err = repo.ListPackagesContext(ctx, hat)
+		if err == nil {
+			return pkgs, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 	}
-	return nil
+	return nil, err
 }
 
 func (repo *RepoInfo) ListPackages(hat string) []Package {
+	pkgs, _ := repo.ListPackagesContext(context.Background(), hat)
+	return pkgs
+}
+
+// ListPackagesContext is like ListPackages but the repository is not
+// queried any more once ctx is done.
+func (repo *RepoInfo) ListPackagesContext(ctx context.Context, hat string) ([]Package, error) {
 	if repo.pkgs == nil {
-		err := repo.initPkgs()
+		err := repo.initPkgs(ctx)
 		if err != nil {
-			return nil
+			return nil, err
 		}
 	}
 	pkgs := make([]Package, 0)
@@ -97,52 +284,16 @@ func (repo *RepoInfo) ListPackages(hat string) []Package {
 		}
 		pkgs = append(pkgs, pkg)
 	}
-	return pkgs
+	return pkgs, nil
 }
 
-func (repo *RepoInfo) initPkgs() error {
-	var err error
-
-	// FIXME: first check 'propget version' >= 2.0
-
-	// assume propget-version >= 2.0
-	bout, err := vcs.Run(repo.Cmd, "propget packages {repo}", "repo", repo.Repo)
-	if err != nil {
-		return nil
+func (repo *RepoInfo) initPkgs(ctx context.Context) error {
+	idx, ok := indices[repo.VCS]
+	if !ok {
+		return fmt.Errorf("lbctx: no package index for vcs %q (repository [%s])", repo.VCS, repo.Repo)
 	}
 
-	pkgs := make(Packages)
-	scan := bufio.NewScanner(bytes.NewReader(bout))
-	for scan.Scan() {
-		bline := bytes.Trim(scan.Bytes(), " \n")
-		if bytes.HasPrefix(bline, []byte("#")) {
-			continue
-		}
-		bline = bytes.Replace(bline, []byte("\t"), []byte(" "), -1)
-		tokens := make([]string, 0)
-		for _, tok := range strings.Split(string(bline), " ") {
-			tok = strings.Trim(tok, " \t\n")
-			if tok == "" {
-				continue
-			}
-			tokens = append(tokens, tok)
-		}
-
-		if len(tokens) <= 0 {
-			continue
-		}
-		project := ""
-		pkgname := tokens[0]
-		if len(tokens) > 1 {
-			project = tokens[1]
-		}
-		pkgs[pkgname] = Package{
-			Name:    pkgname,
-			Project: project,
-			Repo:    repo.Repo,
-		}
-	}
-	err = scan.Err()
+	pkgs, err := idx.Packages(ctx, repo)
 	if err != nil {
 		return err
 	}
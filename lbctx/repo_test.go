@@ -0,0 +1,116 @@
+package lbctx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lhcb-org/lbx/lbctx/vcs"
+)
+
+func TestMakeRepoDb(t *testing.T) {
+	cfgs := mergeRepoConfigs(DefaultRepos, []RepoConfig{
+		{
+			Name:      "gitlab",
+			VCS:       "git",
+			URLs:      []string{"{protocol}://{user}@gitlab.cern.ch/lhcb"},
+			Protocols: []string{"https", "ssh"},
+			Priority:  10,
+		},
+		{
+			Name:     "local",
+			VCS:      "svn",
+			URLs:     []string{"file:///data/svn/test"},
+			Priority: -1,
+		},
+		{
+			Name:     "lhcbint",
+			Disabled: true,
+		},
+	})
+
+	for _, table := range []struct {
+		user     string
+		protocol string
+		want     map[string][]string
+	}{
+		{
+			user: "",
+			want: map[string][]string{
+				"gaudi":  {"svn+ssh://svn.cern.ch/reps/gaudi", "http://svn.cern.ch/guest/gaudi"},
+				"lbsvn":  {"svn+ssh://svn.cern.ch/reps/lhcb", "http://svn.cern.ch/guest/lhcb"},
+				"dirac":  {"svn+ssh://svn.cern.ch/reps/dirac", "http://svn.cern.ch/guest/dirac"},
+				"gitlab": {"https://gitlab.cern.ch/lhcb", "ssh://gitlab.cern.ch/lhcb"},
+				"local":  {"file:///data/svn/test"},
+			},
+		},
+		{
+			user:     "joe",
+			protocol: "ssh",
+			want: map[string][]string{
+				"gaudi":  {"svn+ssh://joe@svn.cern.ch/reps/gaudi"},
+				"lbsvn":  {"svn+ssh://joe@svn.cern.ch/reps/lhcb"},
+				"dirac":  {"svn+ssh://joe@svn.cern.ch/reps/dirac"},
+				"gitlab": {"ssh://joe@gitlab.cern.ch/lhcb"},
+			},
+		},
+		{
+			protocol: "http",
+			want: map[string][]string{
+				"gaudi": {"http://svn.cern.ch/guest/gaudi"},
+				"lbsvn": {"http://svn.cern.ch/guest/lhcb"},
+				"dirac": {"http://svn.cern.ch/guest/dirac"},
+			},
+		},
+	} {
+		db, err := makeRepoDb(cfgs, table.user, table.protocol)
+		if err != nil {
+			t.Fatalf("user=%q protocol=%q: error: %v", table.user, table.protocol, err)
+		}
+		got := make(map[string][]string, len(db))
+		for name, infos := range db {
+			for _, info := range infos {
+				if info.Name != name {
+					t.Fatalf("invalid repository name. expected %q. got=%q", name, info.Name)
+				}
+				got[name] = append(got[name], info.Repo)
+			}
+		}
+		if !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("user=%q protocol=%q:\nexp=%v\ngot=%v", table.user, table.protocol, table.want, got)
+		}
+	}
+
+	db, err := makeRepoDb(cfgs, "", "")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if prio := db["gitlab"][0].Priority; prio != 10 {
+		t.Fatalf("expected priority 10. got=%d", prio)
+	}
+
+	_, err = makeRepoDb([]RepoConfig{{Name: "bad", VCS: "cvs"}}, "", "")
+	if err == nil {
+		t.Fatalf("expected an error for an unknown vcs")
+	}
+}
+
+func TestDeprecatedRepos(t *testing.T) {
+	for _, table := range []struct {
+		name string
+		want []string
+	}{
+		{"gaudi", []string{"svn+ssh://svn.cern.ch/reps/gaudi", "http://svn.cern.ch/guest/gaudi"}},
+		{"lhcbint", []string{"svn+ssh://svn.cern.ch/reps/lhcbint"}},
+	} {
+		var got []string
+		for _, info := range Repos[table.name] {
+			if info.Cmd != vcs.Svn {
+				t.Fatalf("%s: expected an svn repository. got=%v", table.name, info.Cmd)
+			}
+			got = append(got, info.Repo)
+		}
+		if !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("%s:\nexp=%v\ngot=%v", table.name, table.want, got)
+		}
+	}
+}
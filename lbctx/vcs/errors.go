@@ -0,0 +1,181 @@
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ErrorKind classifies the failures of version control commands.
+type ErrorKind int
+
+const (
+	ErrOther    ErrorKind = iota // unclassified failure
+	ErrAuth                      // authentication or authorization failure
+	ErrNotFound                  // missing repository, path, tag or branch
+	ErrNetwork                   // host unreachable, connection refused or reset...
+	ErrConflict                  // conflicts left in a working copy
+	ErrCanceled                  // command killed on cancellation
+	ErrTimeout                   // command killed on timeout
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrAuth:
+		return "auth"
+	case ErrNotFound:
+		return "not-found"
+	case ErrNetwork:
+		return "network"
+	case ErrConflict:
+		return "conflict"
+	case ErrCanceled:
+		return "canceled"
+	case ErrTimeout:
+		return "timeout"
+	}
+	return "other"
+}
+
+// Error describes a failed version control command.
+type Error struct {
+	VCS        string   // command of the version control system (git, svn, ...)
+	Dir        string   // directory the command was run in
+	Args       []string // arguments of the command
+	ExitStatus int      // exit status of the command, -1 if it did not exit
+	Output     []byte   // combined stdout and stderr of the command
+	Err        error    // error from os/exec, or the error of the canceled context
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("vcs: %s %s (in %s): %v", e.VCS, strings.Join(e.Args, " "), e.Dir, e.Err)
+	if line := e.lastLine(); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error, so errors.Is(err, context.Canceled)
+// and errors.Is(err, context.DeadlineExceeded) tell the killed commands.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// lastLine returns the last non-empty line of the output of the command,
+// which usually explains the failure.
+func (e *Error) lastLine() string {
+	lines := bytes.Split(bytes.TrimSpace(e.Output), []byte("\n"))
+	return string(bytes.TrimSpace(lines[len(lines)-1]))
+}
+
+// Kind classifies the failure from the output of the command.
+func (e *Error) Kind() ErrorKind {
+	switch e.Err {
+	case context.Canceled:
+		return ErrCanceled
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+
+	out := strings.ToLower(string(e.Output))
+	for _, c := range errorClasses {
+		for _, pattern := range c.patterns {
+			if strings.Contains(out, pattern) {
+				return c.kind
+			}
+		}
+	}
+	return ErrOther
+}
+
+// errorClasses lists the messages of git, svn, hg and bzr identifying the
+// kind of a failure, in order of precedence.
+var errorClasses = []struct {
+	kind     ErrorKind
+	patterns []string
+}{
+	{ErrAuth, []string{
+		"authentication failed",
+		"authorization failed",
+		"permission denied",
+		"could not read username",
+		"could not read password",
+		"access denied",
+		"host key verification failed",
+		"e170001", // svn: authorization failed
+		"e215004", // svn: no more credentials
+		"abort: http authorization required",
+	}},
+	{ErrNetwork, []string{
+		"could not resolve host",
+		"could not resolve hostname",
+		"name or service not known",
+		"connection refused",
+		"connection timed out",
+		"connection reset",
+		"operation timed out",
+		"network is unreachable",
+		"no route to host",
+		"unable to connect",
+		"the remote end hung up unexpectedly",
+		"e170013", // svn: unable to connect to a repository
+		"e670002", // svn: unknown host
+		"e731001", // svn: unknown host
+		"e000110", // svn: connection timed out
+		"e000111", // svn: connection refused
+	}},
+	{ErrNotFound, []string{
+		"did not match any file(s) known to git",
+		"unknown revision",
+		"couldn't find remote ref",
+		"not a valid object name",
+		"invalid reference",
+		"repository not found",
+		"does not appear to be a git repository",
+		"doesn't exist",
+		"does not exist",
+		"path not found",
+		"not a working copy",
+		"e160013", // svn: path not found
+		"e170000", // svn: URL doesn't exist
+		"abort: unknown revision",
+		"abort: repository",
+	}},
+}
+
+// KindOf returns the kind of the failure err, if it is an *Error, a
+// *ConflictError or a context error, and ErrOther otherwise.
+func KindOf(err error) ErrorKind {
+	switch err := err.(type) {
+	case *Error:
+		return err.Kind()
+	case *ConflictError:
+		return ErrConflict
+	}
+	switch err {
+	case context.Canceled:
+		return ErrCanceled
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+	return ErrOther
+}
+
+// newError returns the *Error for the command of v with arguments args, run in
+// dir, which failed with err and the given output.
+func newError(v *Cmd, dir string, args []string, out []byte, err error) *Error {
+	status := -1
+	if ee, ok := err.(*exec.ExitError); ok {
+		status = ee.ExitCode()
+	}
+	return &Error{
+		VCS:        v.cmd,
+		Dir:        dir,
+		Args:       args,
+		ExitStatus: status,
+		Output:     out,
+		Err:        err,
+	}
+}
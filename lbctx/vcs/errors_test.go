@@ -0,0 +1,109 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorKind(t *testing.T) {
+	for _, table := range []struct {
+		out  string
+		kind ErrorKind
+	}{
+		{"error: pathspec 'v9r9' did not match any file(s) known to git\n", ErrNotFound},
+		{"svn: E170000: URL 'svn+ssh://svn.cern.ch/reps/lhcb/Pkg/tags/v9r9' doesn't exist\n", ErrNotFound},
+		{"fatal: could not read Username for 'https://gitlab.cern.ch': terminal prompts disabled\n", ErrAuth},
+		{"Permission denied (publickey,gssapi-keyex,gssapi-with-mic).\nfatal: Could not read from remote repository.\n", ErrAuth},
+		{"svn: E170013: Unable to connect to a repository at URL 'svn+ssh://svn.cern.ch/reps/lhcb'\n", ErrNetwork},
+		{"fatal: unable to access 'https://gitlab.cern.ch/lhcb/LHCb.git/': Could not resolve host: gitlab.cern.ch\n", ErrNetwork},
+		{"fatal: not something we can merge\n", ErrOther},
+		{"", ErrOther},
+	} {
+		err := &Error{VCS: "git", Output: []byte(table.out)}
+		if kind := err.Kind(); kind != table.kind {
+			t.Errorf("%q: expected kind %v. got=%v", table.out, table.kind, kind)
+		}
+		if kind := KindOf(err); kind != table.kind {
+			t.Errorf("%q: expected KindOf %v. got=%v", table.out, table.kind, kind)
+		}
+	}
+
+	if kind := KindOf(&ConflictError{}); kind != ErrConflict {
+		t.Errorf("expected KindOf(*ConflictError) to be %v. got=%v", ErrConflict, kind)
+	}
+}
+
+func TestErrorGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-error-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	git(t, top, "init", "-q")
+	git(t, top, "commit", "-q", "--allow-empty", "-m", "initial import")
+
+	err = Git.run(top, "checkout {tag}", "tag", "v9r9")
+	if err == nil {
+		t.Fatalf("expected an error checking out a missing tag")
+	}
+	verr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected a *vcs.Error. got=%T (%v)", err, err)
+	}
+	if verr.VCS != "git" || verr.Dir != top {
+		t.Errorf("unexpected command: vcs=%q dir=%q", verr.VCS, verr.Dir)
+	}
+	if strings.Join(verr.Args, " ") != "checkout v9r9" {
+		t.Errorf("unexpected arguments: %q", verr.Args)
+	}
+	if verr.ExitStatus <= 0 {
+		t.Errorf("expected a non-zero exit status. got=%d", verr.ExitStatus)
+	}
+	if verr.Kind() != ErrNotFound {
+		t.Errorf("expected a %v error. got=%v\n%s", ErrNotFound, verr.Kind(), string(verr.Output))
+	}
+	if !strings.Contains(verr.Error(), "v9r9") {
+		t.Errorf("expected the error message to mention the tag. got=%q", verr.Error())
+	}
+}
+
+func TestErrorTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+
+	// a command hanging e.g. on a password prompt.
+	hang := &Cmd{name: "sleep", cmd: "sleep"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := hang.run1Context(ctx, ".", "{delay}", []string{"delay", "30"})
+	if err == nil {
+		t.Fatalf("expected the command to time out")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("command killed too late: %v", d)
+	}
+	if kind := KindOf(err); kind != ErrTimeout {
+		t.Fatalf("expected a %v error. got=%v (%v)", ErrTimeout, kind, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the error to wrap %v. got=%v", context.DeadlineExceeded, err)
+	}
+	if verr := err.(*Error); verr.ExitStatus != -1 {
+		t.Errorf("expected no exit status for a killed command. got=%d", verr.ExitStatus)
+	}
+}
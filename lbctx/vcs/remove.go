@@ -0,0 +1,175 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RemovePackage removes the package checked out in pkgdir.
+//
+// RemovePackage refuses to remove a package with uncommitted changes,
+// untracked files or (for git) commits not pushed to any remote, unless
+// force is true.
+// For git sparse checkouts, the package is also removed from the
+// sparse-checkout file of its repository.
+// Packages which are not under version control are only removed when
+// force is true.
+func RemovePackage(pkgdir string, force bool) error {
+	dir, err := filepath.Abs(pkgdir)
+	if err != nil {
+		return err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("vcs: [%s] is not a directory", pkgdir)
+	}
+
+	if path_exists(filepath.Join(dir, ".svn")) {
+		return svn_remove(dir, force)
+	}
+
+	if bout, err := Git.run1(dir, "rev-parse --show-toplevel", nil); err == nil {
+		root := string(bytes.TrimSpace(bout))
+		root, err = filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		return git_remove(root, dir, force)
+	}
+
+	if !force {
+		return fmt.Errorf("vcs: [%s] is not under version control. refusing to remove it", pkgdir)
+	}
+	return os.RemoveAll(dir)
+}
+
+func svn_remove(dir string, force bool) error {
+	if !force {
+		bout, err := Svn.run1(dir, "status", nil)
+		if err != nil {
+			return err
+		}
+		if bout = bytes.TrimSpace(bout); len(bout) > 0 {
+			return fmt.Errorf("vcs.svn: [%s] has local changes:\n%s", dir, string(bout))
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+func git_remove(root, dir string, force bool) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	if !force {
+		err = git_check_pushed(root, rel)
+		if err != nil {
+			return err
+		}
+	}
+
+	if rel == "." {
+		return os.RemoveAll(root)
+	}
+
+	sparse := filepath.Join(root, ".git", "info", "sparse-checkout")
+	if !path_exists(sparse) {
+		if !force {
+			return fmt.Errorf(
+				"vcs.git: [%s] is part of the git repository [%s] which is not a sparse checkout. refusing to remove it",
+				dir, root,
+			)
+		}
+		return os.RemoveAll(dir)
+	}
+
+	paths, err := git_read_sparse_checkout(sparse)
+	if err != nil {
+		return err
+	}
+	entry := strings.TrimSuffix(rel, "/") + "/"
+	if _, ok := paths[entry]; !ok {
+		return fmt.Errorf(
+			"vcs.git: package [%s] not in sparse-checkout file [%s]",
+			entry, sparse,
+		)
+	}
+
+	if len(paths) == 1 {
+		// last package of the repository: remove the whole repository,
+		// once it is known to hold nothing else worth keeping.
+		if !force {
+			err = git_check_pushed(root, ".")
+			if err != nil {
+				return err
+			}
+		}
+		return os.RemoveAll(root)
+	}
+
+	_, err = git_remove_sparse_checkout(sparse, rel)
+	if err != nil {
+		return err
+	}
+
+	err = Git.run(root, "read-tree -mu HEAD")
+	if err != nil {
+		return err
+	}
+
+	// remove whatever is left (ignored files, build products, ...)
+	return os.RemoveAll(dir)
+}
+
+// git_check_pushed checks that path in the git repository root has no
+// uncommitted changes, no untracked files and no commit which has not been
+// pushed to a remote, on any local branch or on HEAD.
+// For the whole repository (path "."), it also checks there is no stash.
+func git_check_pushed(root, path string) error {
+	bout, err := Git.run1(root, "status --porcelain -- {path}", []string{"path", path})
+	if err != nil {
+		return err
+	}
+	if bout = bytes.TrimSpace(bout); len(bout) > 0 {
+		return fmt.Errorf("vcs.git: [%s] has local changes:\n%s", filepath.Join(root, path), string(bout))
+	}
+
+	// local tags are not pushed either: only remote branches count.
+	// the whole repository is not limited to a path, so commits without
+	// changes (e.g. merges) are listed as well.
+	logcmd := "log --oneline --branches HEAD --not --remotes -- {path}"
+	if path == "." {
+		logcmd = "log --oneline --branches HEAD --not --remotes"
+	}
+	bout, err = Git.run1(root, logcmd, []string{"path", path})
+	if err != nil {
+		return err
+	}
+	if bout = bytes.TrimSpace(bout); len(bout) > 0 {
+		return fmt.Errorf("vcs.git: [%s] has unpushed commits:\n%s", filepath.Join(root, path), string(bout))
+	}
+
+	if path != "." {
+		return nil
+	}
+	bout, err = Git.run1(root, "stash list", nil)
+	if err != nil {
+		return err
+	}
+	if bout = bytes.TrimSpace(bout); len(bout) > 0 {
+		return fmt.Errorf("vcs.git: [%s] has stashed changes:\n%s", root, string(bout))
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=lbx", "-c", "user.email=lbx@example.com",
+	}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, string(out))
+	}
+}
+
+func TestRemovePackageGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-rm-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	// create the upstream repository
+	upstream := filepath.Join(top, "upstream")
+	for _, pkg := range []string{"Hat/A", "Hat/B"} {
+		err = os.MkdirAll(filepath.Join(upstream, pkg), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(upstream, pkg, "CMakeLists.txt"), []byte(pkg+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "initial import")
+
+	// create a sparse checkout of both packages
+	repo := filepath.Join(top, "work", "Repo")
+	err = os.MkdirAll(repo, 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, repo, "init", "-q")
+	git(t, repo, "remote", "add", "origin", upstream)
+	git(t, repo, "fetch", "-q", "origin")
+	git(t, repo, "config", "core.sparsecheckout", "true")
+	sparse := filepath.Join(repo, ".git", "info", "sparse-checkout")
+	err = ioutil.WriteFile(sparse, []byte("Hat/A/\nHat/B/\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, repo, "checkout", "-q", "FETCH_HEAD")
+
+	for _, pkg := range []string{"Hat/A", "Hat/B"} {
+		if !path_exists(filepath.Join(repo, pkg)) {
+			t.Fatalf("expected package %s to be checked out", pkg)
+		}
+	}
+
+	// a clean package can be removed.
+	err = RemovePackage(filepath.Join(repo, "Hat", "A"), false)
+	if err != nil {
+		t.Fatalf("error removing Hat/A: %v", err)
+	}
+	if path_exists(filepath.Join(repo, "Hat", "A")) {
+		t.Fatalf("expected Hat/A to be removed")
+	}
+	content, err := ioutil.ReadFile(sparse)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if string(content) != "Hat/B/\n" {
+		t.Fatalf("invalid sparse-checkout file: %q", string(content))
+	}
+
+	// a modified package is kept...
+	err = ioutil.WriteFile(filepath.Join(repo, "Hat", "B", "CMakeLists.txt"), []byte("modified\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = RemovePackage(filepath.Join(repo, "Hat", "B"), false)
+	if err == nil || !strings.Contains(err.Error(), "local changes") {
+		t.Fatalf("expected a local changes error. got=%v", err)
+	}
+	if !path_exists(filepath.Join(repo, "Hat", "B")) {
+		t.Fatalf("expected Hat/B to be kept")
+	}
+
+	// ...and so is a package with unpushed commits...
+	git(t, repo, "commit", "-q", "-a", "-m", "local work")
+	err = RemovePackage(filepath.Join(repo, "Hat", "B"), false)
+	if err == nil || !strings.Contains(err.Error(), "unpushed commits") {
+		t.Fatalf("expected an unpushed commits error. got=%v", err)
+	}
+
+	// ...unless forced. the last package takes the repository with it.
+	err = RemovePackage(filepath.Join(repo, "Hat", "B"), true)
+	if err != nil {
+		t.Fatalf("error removing Hat/B: %v", err)
+	}
+	if path_exists(repo) {
+		t.Fatalf("expected repository to be removed")
+	}
+}
+
+func TestRemovePackageGitLastRefused(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-rm-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	upstream := filepath.Join(top, "upstream")
+	err = os.MkdirAll(filepath.Join(upstream, "Hat", "A"), 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(upstream, "Hat", "A", "CMakeLists.txt"), []byte("A\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "initial import")
+
+	repo := filepath.Join(top, "work", "Repo")
+	err = os.MkdirAll(repo, 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, repo, "init", "-q")
+	git(t, repo, "remote", "add", "origin", upstream)
+	git(t, repo, "fetch", "-q", "origin")
+	git(t, repo, "config", "core.sparsecheckout", "true")
+	sparse := filepath.Join(repo, ".git", "info", "sparse-checkout")
+	err = ioutil.WriteFile(sparse, []byte("Hat/A/\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, repo, "checkout", "-q", "FETCH_HEAD")
+
+	// unpushed work outside of the (clean) last package.
+	err = ioutil.WriteFile(filepath.Join(repo, "NOTES"), []byte("notes\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	err = RemovePackage(filepath.Join(repo, "Hat", "A"), false)
+	if err == nil {
+		t.Fatalf("expected the removal of the last package to be refused")
+	}
+	if !path_exists(filepath.Join(repo, "Hat", "A", "CMakeLists.txt")) {
+		t.Fatalf("expected Hat/A to be kept")
+	}
+	content, err := ioutil.ReadFile(sparse)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if string(content) != "Hat/A/\n" {
+		t.Fatalf("expected the sparse-checkout file to be unchanged. got=%q", string(content))
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(sparse))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, fi := range files {
+		if fi.Name() != "sparse-checkout" && strings.HasPrefix(fi.Name(), "sparse-checkout") {
+			t.Errorf("unexpected file left behind: %s", fi.Name())
+		}
+	}
+}
+
+func TestRemovePackageGitUnpushedElsewhere(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-rm-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	upstream := filepath.Join(top, "upstream")
+	err = os.MkdirAll(upstream, 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(upstream, "CMakeLists.txt"), []byte("Pkg\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "initial import")
+
+	for _, table := range []struct {
+		name string
+		work []string // git commands leaving some local work behind
+		want string
+	}{
+		{
+			name: "branch",
+			work: []string{
+				"checkout -q -b side",
+				"commit -q --allow-empty -m side-work",
+				"checkout -q -",
+			},
+			want: "unpushed commits",
+		},
+		{
+			name: "tag",
+			work: []string{
+				"checkout -q --detach",
+				"commit -q --allow-empty -m tagged-work",
+				"tag local-tag",
+			},
+			want: "unpushed commits",
+		},
+		{
+			name: "stash",
+			work: []string{
+				"stash -q",
+			},
+			want: "stashed changes",
+		},
+	} {
+		repo := filepath.Join(top, "work-"+table.name)
+		git(t, top, "clone", "-q", upstream, repo)
+		if table.name == "stash" {
+			err = ioutil.WriteFile(filepath.Join(repo, "CMakeLists.txt"), []byte("modified\n"), 0644)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+		}
+		for _, args := range table.work {
+			git(t, repo, strings.Fields(args)...)
+		}
+
+		// the whole repository is the package.
+		err = RemovePackage(repo, false)
+		if err == nil || !strings.Contains(err.Error(), table.want) {
+			t.Fatalf("%s: expected a %q error. got=%v", table.name, table.want, err)
+		}
+		if !path_exists(repo) {
+			t.Fatalf("%s: expected repository to be kept", table.name)
+		}
+	}
+}
+
+func TestRemovePackageUnversioned(t *testing.T) {
+	top, err := ioutil.TempDir("", "lbx-vcs-rm-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	pkg := filepath.Join(top, "Hat", "Local")
+	err = os.MkdirAll(pkg, 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	err = RemovePackage(pkg, false)
+	if err == nil {
+		t.Fatalf("expected an error removing an unversioned package")
+	}
+	err = RemovePackage(pkg, true)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if path_exists(pkg) {
+		t.Fatalf("expected package to be removed")
+	}
+}
@@ -0,0 +1,208 @@
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Status describes the state of a package checked out in a work area.
+type Status struct {
+	Dir      string   `json:"dir"`
+	VCS      string   `json:"vcs"`      // "svn", "git" or "" when not under version control
+	Tag      string   `json:"tag"`      // tag or branch the package is checked out at
+	Revision string   `json:"revision"` // revision recorded in version.lbx at checkout
+	Modified []string `json:"modified"` // locally modified or untracked files
+	Upstream string   `json:"upstream"` // what Ahead and Behind are relative to ("" if unknown)
+	Ahead    int      `json:"ahead"`    // local commits not in upstream
+	Behind   int      `json:"behind"`   // upstream commits (or out-of-date files for svn) not checked out
+}
+
+// PackageStatus returns the status of the package checked out in pkgdir.
+//
+// The ahead/behind counts of git packages are computed against the
+// remote-tracking branches, as last fetched.
+// svn packages are only compared with their repository when remote is true.
+func PackageStatus(pkgdir string, remote bool) (Status, error) {
+	st := Status{Dir: pkgdir}
+
+	dir, err := filepath.Abs(pkgdir)
+	if err != nil {
+		return st, err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return st, err
+	}
+
+	if path_exists(filepath.Join(dir, ".svn")) {
+		err = svn_status(&st, dir, remote)
+		return st, err
+	}
+
+	if bout, err := Git.run1(dir, "rev-parse --show-toplevel", nil); err == nil {
+		root := string(bytes.TrimSpace(bout))
+		root, err = filepath.EvalSymlinks(root)
+		if err != nil {
+			return st, err
+		}
+		err = git_status(&st, root, dir)
+		return st, err
+	}
+
+	st.Revision = read_version_lbx(dir)
+	return st, nil
+}
+
+// read_version_lbx returns the revision stored in the version.lbx file of the
+// first directory of dirs containing one.
+func read_version_lbx(dirs ...string) string {
+	for _, dir := range dirs {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "version.lbx"))
+		if err == nil {
+			return strings.TrimSpace(string(content))
+		}
+	}
+	return ""
+}
+
+func svn_status(st *Status, dir string, remote bool) error {
+	st.VCS = "svn"
+	st.Revision = read_version_lbx(dir)
+
+	bout, err := Svn.run1(dir, "info", nil)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(bout))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "URL: ") {
+			st.Tag = svn_tag(strings.TrimPrefix(line, "URL: "))
+			if remote {
+				st.Upstream = strings.TrimPrefix(line, "URL: ")
+			}
+		}
+	}
+
+	cmdline := "status"
+	if remote {
+		cmdline = "status -u"
+	}
+	bout, err = Svn.run1(dir, cmdline, nil)
+	if err != nil {
+		return err
+	}
+
+	// status lines are made of 7 columns of flags, a blank and, with -u,
+	// a '*' for files out-of-date with respect to the repository.
+	scanner = bufio.NewScanner(bytes.NewReader(bout))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 9 || strings.HasPrefix(line, "Status against revision:") {
+			continue
+		}
+		fname := strings.TrimSpace(line[8:])
+		if remote {
+			fname = strings.TrimSpace(line[9:])
+			// skip the working revision
+			if fields := strings.Fields(fname); len(fields) > 1 {
+				if _, err := strconv.Atoi(fields[0]); err == nil {
+					fname = strings.TrimSpace(strings.TrimPrefix(fname, fields[0]))
+				}
+			}
+		}
+		if filepath.Base(fname) == "version.lbx" {
+			continue
+		}
+		if remote && line[8] == '*' {
+			st.Behind++
+		}
+		if strings.TrimSpace(line[:7]) != "" {
+			st.Modified = append(st.Modified, strings.TrimSpace(line[:7])+" "+fname)
+		}
+	}
+	return scanner.Err()
+}
+
+// svn_tag returns the tag or branch name from the URL of an svn checkout.
+func svn_tag(url string) string {
+	toks := strings.Split(strings.TrimSuffix(url, "/"), "/")
+	for i, tok := range toks {
+		switch tok {
+		case "trunk":
+			return tok
+		case "tags", "branches":
+			if i+1 < len(toks) {
+				return toks[len(toks)-1]
+			}
+		}
+	}
+	return ""
+}
+
+func git_status(st *Status, root, dir string) error {
+	st.VCS = "git"
+	st.Revision = read_version_lbx(dir, root)
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	run := func(cmdline string, keyval ...string) (string, error) {
+		bout, err := Git.run1(root, cmdline, keyval)
+		return string(bytes.TrimSpace(bout)), err
+	}
+
+	if branch, err := run("symbolic-ref -q --short HEAD"); err == nil {
+		st.Tag = branch
+	} else if tag, err := run("describe --tags --exact-match HEAD"); err == nil {
+		st.Tag = tag
+	} else {
+		st.Tag, err = run("rev-parse --short HEAD")
+		if err != nil {
+			return err
+		}
+	}
+
+	// the leading blanks of the porcelain format are significant.
+	bout, err := Git.run1(root, "status --porcelain -- {path}", []string{"path", rel})
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(bout), "\n") {
+		// lines are "XY <path>": only the path tells the version.lbx files.
+		if len(line) < 4 || path.Base(line[3:]) == "version.lbx" {
+			continue
+		}
+		st.Modified = append(st.Modified, line)
+	}
+
+	if upstream, err := run("rev-parse --abbrev-ref --symbolic-full-name @{u}"); err == nil {
+		st.Upstream = upstream
+		out, err := run("rev-list --left-right --count HEAD...@{u} -- {path}", "path", rel)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Sscanf(out, "%d %d", &st.Ahead, &st.Behind)
+		if err != nil {
+			return fmt.Errorf("vcs.git: could not parse ahead/behind counts %q: %v", out, err)
+		}
+		return nil
+	}
+
+	// no upstream branch (e.g. a detached tag): count the commits not on any remote.
+	out, err := run("rev-list --count HEAD --not --remotes --tags -- {path}", "path", rel)
+	if err != nil {
+		return err
+	}
+	st.Ahead, err = strconv.Atoi(out)
+	return err
+}
@@ -0,0 +1,133 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageStatusGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-status-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	upstream := filepath.Join(top, "upstream")
+	err = os.MkdirAll(filepath.Join(upstream, "Hat", "A"), 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(upstream, "Hat", "A", "CMakeLists.txt"), []byte("A\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "checkout", "-q", "-b", "master")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "initial import")
+
+	repo := filepath.Join(top, "work", "Repo")
+	git(t, top, "clone", "-q", upstream, repo)
+	err = ioutil.WriteFile(filepath.Join(repo, "version.lbx"), []byte("Hat/A-1234567\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	pkgdir := filepath.Join(repo, "Hat", "A")
+	st, err := PackageStatus(pkgdir, false)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if st.VCS != "git" || st.Tag != "master" || st.Revision != "Hat/A-1234567" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if st.Upstream != "origin/master" || st.Ahead != 0 || st.Behind != 0 || len(st.Modified) != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	// the whole repository, with its (untracked) version.lbx at the top.
+	st, err = PackageStatus(repo, false)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(st.Modified) != 0 {
+		t.Fatalf("unexpected modifications of the repository: %q", st.Modified)
+	}
+	err = ioutil.WriteFile(filepath.Join(repo, "NOTES"), []byte("notes\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	st, err = PackageStatus(repo, false)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(st.Modified) != 1 || st.Modified[0] != "?? NOTES" {
+		t.Fatalf("unexpected modifications of the repository: %q", st.Modified)
+	}
+	err = os.Remove(filepath.Join(repo, "NOTES"))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// one local commit, one upstream commit and a local modification.
+	err = ioutil.WriteFile(filepath.Join(pkgdir, "CMakeLists.txt"), []byte("local\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, repo, "commit", "-q", "-a", "-m", "local work")
+	err = ioutil.WriteFile(filepath.Join(pkgdir, "new.txt"), []byte("new\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(upstream, "Hat", "A", "CMakeLists.txt"), []byte("upstream\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "commit", "-q", "-a", "-m", "upstream work")
+	git(t, repo, "fetch", "-q", "origin")
+
+	st, err = PackageStatus(pkgdir, false)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if st.Ahead != 1 || st.Behind != 1 {
+		t.Fatalf("expected ahead=1 behind=1. got=%+v", st)
+	}
+	if len(st.Modified) != 1 || st.Modified[0] != "?? Hat/A/new.txt" {
+		t.Fatalf("unexpected modifications: %q", st.Modified)
+	}
+
+	// a detached tag has no upstream.
+	git(t, repo, "tag", "v1r0", "origin/master")
+	git(t, repo, "checkout", "-q", "v1r0")
+	st, err = PackageStatus(pkgdir, false)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if st.Tag != "v1r0" || st.Upstream != "" || st.Ahead != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestSvnTag(t *testing.T) {
+	for _, table := range []struct {
+		url  string
+		want string
+	}{
+		{"svn+ssh://svn.cern.ch/reps/lhcb/Phys/trunk/Phys/DaVinciKernel", "trunk"},
+		{"svn+ssh://svn.cern.ch/reps/lhcb/Phys/tags/Phys/DaVinciKernel/v10r2", "v10r2"},
+		{"svn+ssh://svn.cern.ch/reps/lhcb/Phys/branches/Phys/DaVinciKernel/v10r2b", "v10r2b"},
+		{"file:///some/where", ""},
+	} {
+		if got := svn_tag(table.url); got != table.want {
+			t.Fatalf("%s: expected %q. got=%q", table.url, table.want, got)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ConflictError is returned when updating a package left conflicts in its
+// working copy.
+type ConflictError struct {
+	Dir   string   // directory of the package
+	Files []string // files in conflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("vcs: conflicts in [%s]: %s", e.Dir, strings.Join(e.Files, ", "))
+}
+
+// UpdatePackage brings the package checked out in pkgdir up to date.
+//
+// If tag is empty, the upstream changes are pulled in: svn packages are
+// updated and git packages are merged with their upstream branch. Like svn
+// packages checked out from a tag, detached git checkouts (e.g. of a tag)
+// are left where they are, and git branches without an upstream branch are
+// not updated.
+// Otherwise, the package is switched to tag: svn packages are switched to
+// the tags/ path of their repository and git packages are synced to the git
+// tag or branch. "head" and "trunk" switch svn packages back to the trunk.
+//
+// Note that git packages are part of a repository: switching one package
+// to a tag switches all the packages checked out from the same repository.
+func UpdatePackage(pkgdir, tag string) error {
+	dir, err := filepath.Abs(pkgdir)
+	if err != nil {
+		return err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	if path_exists(filepath.Join(dir, ".svn")) {
+		return svn_update(dir, tag)
+	}
+
+	if bout, err := Git.run1(dir, "rev-parse --show-toplevel", nil); err == nil {
+		root := string(bytes.TrimSpace(bout))
+		root, err = filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		return git_update(root, dir, tag)
+	}
+
+	return fmt.Errorf("vcs: [%s] is not under version control", pkgdir)
+}
+
+// RepositoryRoot returns the top-level directory of the git repository the
+// package checked out in pkgdir belongs to, and pkgdir itself for the other
+// packages. Packages with the same root are updated together.
+func RepositoryRoot(pkgdir string) (string, error) {
+	dir, err := filepath.Abs(pkgdir)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if path_exists(filepath.Join(dir, ".svn")) {
+		return dir, nil
+	}
+
+	if bout, err := Git.run1(dir, "rev-parse --show-toplevel", nil); err == nil {
+		return filepath.EvalSymlinks(string(bytes.TrimSpace(bout)))
+	}
+	return dir, nil
+}
+
+// svn_conflict matches the lines of svn update/switch reporting a conflict
+// on a file, its properties or the tree.
+var svn_conflict = regexp.MustCompile(`^([ ADUCGE]{4}) +(\S.*)$`)
+
+func svn_update(dir, tag string) error {
+	var err error
+	var bout []byte
+
+	switch tag {
+	case "":
+		bout, err = Svn.run1(dir, "update --non-interactive --accept postpone", nil)
+	default:
+		var info []byte
+		info, err = Svn.run1(dir, "info", nil)
+		if err != nil {
+			return err
+		}
+		var newurl string
+		newurl, err = svn_switch_url(svn_info(info, "URL"), tag)
+		if err != nil {
+			return err
+		}
+		bout, err = Svn.run1(dir, "switch --non-interactive --accept postpone {url}", []string{"url", newurl})
+	}
+	if err != nil {
+		return err
+	}
+
+	var conflicts []string
+	scanner := bufio.NewScanner(bytes.NewReader(bout))
+	for scanner.Scan() {
+		m := svn_conflict.FindStringSubmatch(scanner.Text())
+		if m != nil && strings.Contains(m[1], "C") {
+			conflicts = append(conflicts, m[2])
+		}
+	}
+
+	// retrieve tag/version infos
+	info, err := Svn.run1(dir, "info", nil)
+	if err != nil {
+		return err
+	}
+	rev := filepath.Base(dir) + "-" + svn_info(info, "Revision")
+	if tag != "" && tag != "head" && tag != "trunk" {
+		rev = tag
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "version.lbx"), []byte(rev+"\n"), 0666)
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		return &ConflictError{Dir: dir, Files: conflicts}
+	}
+	return nil
+}
+
+// svn_info returns the value of the field key from the output of svn info.
+func svn_info(info []byte, key string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(info))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, key+": ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, key+": "))
+		}
+	}
+	return ""
+}
+
+// svn_switch_url returns the URL of tag for the package checked out from url.
+// Both the .../trunk/<pkg> and the <pkg>/trunk repository layouts are handled.
+func svn_switch_url(url, tag string) (string, error) {
+	toks := strings.Split(strings.TrimSuffix(url, "/"), "/")
+	for i := len(toks) - 1; i >= 0; i-- {
+		prefix := toks[:i]
+		var name []string
+		switch toks[i] {
+		case "trunk":
+			name = toks[i+1:]
+		case "tags", "branches":
+			if i+1 >= len(toks) {
+				continue
+			}
+			// drop the tag (or branch) name
+			name = toks[i+1 : len(toks)-1]
+		default:
+			continue
+		}
+		var out []string
+		out = append(out, prefix...)
+		switch tag {
+		case "head", "trunk":
+			out = append(out, "trunk")
+			out = append(out, name...)
+		default:
+			out = append(out, "tags")
+			out = append(out, name...)
+			out = append(out, tag)
+		}
+		return strings.Join(out, "/"), nil
+	}
+	return "", fmt.Errorf("vcs.svn: could not find trunk, tags or branches in URL [%s]", url)
+}
+
+func git_update(root, dir, tag string) error {
+	var err error
+
+	unlock := lock_repo(root)
+	defer unlock()
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	err = Git.run(root, Git.downloadCmd)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case tag != "":
+		err = Git.tagSync(context.Background(), root, tag)
+	default:
+		if _, derr := Git.run1(root, "symbolic-ref -q HEAD", nil); derr != nil {
+			// detached checkout (e.g. of a tag): stay there, as svn does.
+			break
+		}
+		if _, uerr := Git.run1(root, "rev-parse --abbrev-ref @{u}", nil); uerr != nil {
+			return fmt.Errorf(
+				"vcs.git: the current branch of [%s] has no upstream branch. use a tag (or branch) to switch to",
+				root,
+			)
+		}
+		_, err = Git.run1(root, "merge --no-edit @{u}", nil)
+		if err != nil {
+			bout, uerr := Git.run1(root, "diff --name-only --diff-filter=U", nil)
+			if uerr == nil && len(bytes.TrimSpace(bout)) > 0 {
+				return &ConflictError{
+					Dir:   dir,
+					Files: strings.Split(string(bytes.TrimSpace(bout)), "\n"),
+				}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// retrieve tag/version infos
+	bout, err := Git.run1(root, "rev-parse --short HEAD", nil)
+	if err != nil {
+		return err
+	}
+	rev := rel + "-" + string(bout)
+	return ioutil.WriteFile(filepath.Join(root, "version.lbx"), []byte(rev), 0666)
+}
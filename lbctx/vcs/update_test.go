@@ -0,0 +1,147 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdatePackageGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-update-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	upstream := filepath.Join(top, "upstream")
+	cmake := filepath.Join(upstream, "Hat", "A", "CMakeLists.txt")
+	err = os.MkdirAll(filepath.Dir(cmake), 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(cmake, []byte("v1\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "checkout", "-q", "-b", "master")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "v1")
+	git(t, upstream, "tag", "v1r0")
+
+	repo := filepath.Join(top, "work", "Repo")
+	git(t, top, "clone", "-q", upstream, repo)
+	git(t, repo, "config", "user.name", "lbx")
+	git(t, repo, "config", "user.email", "lbx@example.com")
+	pkgdir := filepath.Join(repo, "Hat", "A")
+	local := filepath.Join(pkgdir, "CMakeLists.txt")
+
+	err = ioutil.WriteFile(cmake, []byte("v2\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "commit", "-q", "-a", "-m", "v2")
+
+	// pull the upstream changes
+	err = UpdatePackage(pkgdir, "")
+	if err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(local); string(content) != "v2\n" {
+		t.Fatalf("expected the upstream changes. got=%q", string(content))
+	}
+	if !path_exists(filepath.Join(repo, "version.lbx")) {
+		t.Fatalf("expected version.lbx to be written")
+	}
+
+	// switch to a tag
+	err = UpdatePackage(pkgdir, "v1r0")
+	if err != nil {
+		t.Fatalf("error switching to tag: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(local); string(content) != "v1\n" {
+		t.Fatalf("expected the v1r0 content. got=%q", string(content))
+	}
+
+	// a detached tag checkout stays on its tag
+	err = UpdatePackage(pkgdir, "")
+	if err != nil {
+		t.Fatalf("error updating a tag checkout: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(local); string(content) != "v1\n" {
+		t.Fatalf("expected the v1r0 content to be kept. got=%q", string(content))
+	}
+
+	// a branch without upstream is not updated
+	git(t, repo, "checkout", "-q", "-b", "local")
+	err = UpdatePackage(pkgdir, "")
+	if err == nil || !strings.Contains(err.Error(), "no upstream branch") {
+		t.Fatalf("expected a no upstream branch error. got=%v", err)
+	}
+
+	// packages of the same repository share its root
+	root, err := RepositoryRoot(pkgdir)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if want, _ := filepath.EvalSymlinks(repo); root != want {
+		t.Fatalf("expected root [%s]. got=[%s]", want, root)
+	}
+
+	// conflicting local and upstream changes
+	git(t, repo, "checkout", "-q", "master")
+	err = ioutil.WriteFile(local, []byte("local\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, repo, "commit", "-q", "-a", "-m", "local work")
+	err = ioutil.WriteFile(cmake, []byte("v3\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git(t, upstream, "commit", "-q", "-a", "-m", "v3")
+
+	err = UpdatePackage(pkgdir, "")
+	cerr, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected a conflict error. got=%v", err)
+	}
+	if len(cerr.Files) != 1 || cerr.Files[0] != "Hat/A/CMakeLists.txt" {
+		t.Fatalf("unexpected conflicting files: %q", cerr.Files)
+	}
+}
+
+func TestSvnSwitchURL(t *testing.T) {
+	const repo = "svn+ssh://svn.cern.ch/reps/lhcb/Phys"
+	for _, table := range []struct {
+		url  string
+		tag  string
+		want string
+	}{
+		{repo + "/trunk/Phys/DaVinciKernel", "v10r2", repo + "/tags/Phys/DaVinciKernel/v10r2"},
+		{repo + "/tags/Phys/DaVinciKernel/v10r1", "v10r2", repo + "/tags/Phys/DaVinciKernel/v10r2"},
+		{repo + "/branches/Phys/DaVinciKernel/v10r1b", "v10r2", repo + "/tags/Phys/DaVinciKernel/v10r2"},
+		{repo + "/tags/Phys/DaVinciKernel/v10r1", "head", repo + "/trunk/Phys/DaVinciKernel"},
+		{"file:///repo/MyPkg/trunk", "v1r0", "file:///repo/MyPkg/tags/v1r0"},
+		{"file:///repo/MyPkg/tags/v1r0", "trunk", "file:///repo/MyPkg/trunk"},
+	} {
+		got, err := svn_switch_url(table.url, table.tag)
+		if err != nil {
+			t.Fatalf("%s: error: %v", table.url, err)
+		}
+		if got != table.want {
+			t.Fatalf("%s: expected %q. got=%q", table.url, table.want, got)
+		}
+	}
+
+	if _, err := svn_switch_url("file:///repo/MyPkg", "v1r0"); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
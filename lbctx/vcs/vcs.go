@@ -5,20 +5,19 @@ package vcs
 
 import (
 	"bytes"
-	"fmt"
-	"os"
+	"context"
 	"os/exec"
 	//"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // A Cmd describes how to use a version control system
 // like Mercurial, Git, or Subversion.
 type Cmd struct {
-	name    string
-	cmd     string // name of binary to invoke command
-	verbose bool   // printout what we do
+	name string
+	cmd  string // name of binary to invoke command
 
 	createCmd   string // command to download a fresh copy of a repository
 	downloadCmd string // command to download updates into an existing repository
@@ -28,6 +27,8 @@ type Cmd struct {
 	tagSyncCmd     string   // command to sync to specific tag
 	tagSyncDefault string   // command to sync to default tag
 
+	revCmd string // command to print the current revision
+
 	scheme  []string
 	pingCmd string
 }
@@ -78,6 +79,8 @@ var Hg = &Cmd{
 	tagSyncCmd:     "update -r {tag}",
 	tagSyncDefault: "update default",
 
+	revCmd: "identify -i",
+
 	scheme:  []string{"https", "http", "ssh"},
 	pingCmd: "identify {scheme}://{repo}",
 }
@@ -101,6 +104,8 @@ var Git = &Cmd{
 	tagSyncCmd:     "checkout {tag}",
 	tagSyncDefault: "checkout origin/master",
 
+	revCmd: "rev-parse --short HEAD",
+
 	scheme:  []string{"git", "https", "http", "git+ssh"},
 	pingCmd: "ls-remote {scheme}://{repo}",
 }
@@ -120,6 +125,8 @@ var Bzr = &Cmd{
 	tagSyncCmd:     "update -r {tag}",
 	tagSyncDefault: "update -r revno:-1",
 
+	revCmd: "revno",
+
 	scheme:  []string{"https", "http", "bzr", "bzr+ssh"},
 	pingCmd: "info {scheme}://{repo}",
 }
@@ -128,7 +135,6 @@ var Bzr = &Cmd{
 var Svn = &Cmd{
 	name: "Subversion",
 	cmd:  "svn",
-	//verbose: true,
 
 	createCmd:   "checkout {repo} {dir}",
 	downloadCmd: "update",
@@ -144,82 +150,121 @@ func (v *Cmd) String() string {
 	return v.name
 }
 
+// waitDelay is how long a canceled command may take to exit, e.g. when it
+// left children holding its output open, before its output is closed.
+const waitDelay = 5 * time.Second
+
 // run runs the command line cmd in the given directory.
 // keyval is a list of key, value pairs.  run expands
 // instances of {key} in cmd into value, but only after
 // splitting cmd into individual arguments.
-// If an error occurs, run prints the command line and the
-// command's combined stdout+stderr to standard error.
+// If an error occurs, run returns an *Error holding the command line and
+// the command's combined stdout+stderr.
 // Otherwise run discards the command's output.
 func (v *Cmd) run(dir string, cmd string, keyval ...string) error {
-	_, err := v.run1(dir, cmd, keyval, true)
-	return err
+	return v.runContext(context.Background(), dir, cmd, keyval...)
 }
 
-// runVerboseOnly is like run but only generates error output to standard error in verbose mode.
-func (v *Cmd) runVerboseOnly(dir string, cmd string, keyval ...string) error {
-	_, err := v.run1(dir, cmd, keyval, false)
+// runContext is like run but the command is killed if ctx is done before
+// the command completes.
+func (v *Cmd) runContext(ctx context.Context, dir string, cmd string, keyval ...string) error {
+	_, err := v.run1Context(ctx, dir, cmd, keyval)
 	return err
 }
 
 // runOutput is like run but returns the output of the command.
 func (v *Cmd) runOutput(dir string, cmd string, keyval ...string) ([]byte, error) {
-	return v.run1(dir, cmd, keyval, true)
+	return v.run1Context(context.Background(), dir, cmd, keyval)
+}
+
+// runOutputContext is like runOutput but the command is killed if ctx is
+// done before the command completes.
+func (v *Cmd) runOutputContext(ctx context.Context, dir string, cmd string, keyval ...string) ([]byte, error) {
+	return v.run1Context(ctx, dir, cmd, keyval)
 }
 
 // run1 is the generalized implementation of run and runOutput.
-func (v *Cmd) run1(dir string, cmdline string, keyval []string, verbose bool) ([]byte, error) {
-	m := make(map[string]string)
-	for i := 0; i < len(keyval); i += 2 {
-		m[keyval[i]] = keyval[i+1]
-	}
-	args := strings.Fields(cmdline)
-	for i, arg := range args {
-		args[i] = expand(m, arg)
-	}
+func (v *Cmd) run1(dir string, cmdline string, keyval []string) ([]byte, error) {
+	return v.run1Context(context.Background(), dir, cmdline, keyval)
+}
 
-	cmd := exec.Command(v.cmd, args...)
+// run1Context is like run1 but the command is killed if ctx is done before
+// the command completes. The *Error then holds ctx.Err().
+func (v *Cmd) run1Context(ctx context.Context, dir string, cmdline string, keyval []string) ([]byte, error) {
+	cmd := v.command(ctx, cmdline, keyval)
 	cmd.Dir = dir
-	if v.verbose {
-		fmt.Printf("cd %s\n", dir)
-		fmt.Printf("%s %s\n", v.cmd, strings.Join(args, " "))
-	}
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
 	err := cmd.Run()
 	out := buf.Bytes()
 	if err != nil {
-		if verbose || v.verbose {
-			fmt.Fprintf(os.Stderr, "# cd %s; %s %s\n", dir, v.cmd, strings.Join(args, " "))
-			os.Stderr.Write(out)
+		if ctx.Err() != nil {
+			err = ctx.Err()
 		}
-		return nil, err
+		return nil, newError(v, dir, cmd.Args[1:], out, err)
 	}
 	return out, nil
 }
 
+// command returns the command running the command line cmdline, with the
+// {key} instances expanded from the keyval pairs, killed when ctx is done.
+func (v *Cmd) command(ctx context.Context, cmdline string, keyval []string) *exec.Cmd {
+	m := make(map[string]string)
+	for i := 0; i < len(keyval); i += 2 {
+		m[keyval[i]] = keyval[i+1]
+	}
+	args := strings.Fields(cmdline)
+	for i, arg := range args {
+		args[i] = expand(m, arg)
+	}
+
+	cmd := exec.CommandContext(ctx, v.cmd, args...)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
 // Ping pings to determine scheme to use.
 func (v *Cmd) Ping(scheme, repo string) error {
-	return v.runVerboseOnly(".", v.pingCmd, "scheme", scheme, "repo", repo)
+	return v.PingContext(context.Background(), scheme, repo)
+}
+
+// PingContext is like Ping but gives up when ctx is done.
+func (v *Cmd) PingContext(ctx context.Context, scheme, repo string) error {
+	return v.runContext(ctx, ".", v.pingCmd, "scheme", scheme, "repo", repo)
 }
 
 // Create creates a new copy of repo in dir.
 // The parent of dir must exist; dir must not.
 func (v *Cmd) Create(dir, repo string) error {
-	return v.run(".", v.createCmd, "dir", dir, "repo", repo)
+	return v.CreateContext(context.Background(), dir, repo)
+}
+
+// CreateContext is like Create but gives up when ctx is done.
+func (v *Cmd) CreateContext(ctx context.Context, dir, repo string) error {
+	return v.runContext(ctx, ".", v.createCmd, "dir", dir, "repo", repo)
 }
 
 // Download downloads any new changes for the repo in dir.
 func (v *Cmd) Download(dir string) error {
-	return v.run(dir, v.downloadCmd)
+	return v.DownloadContext(context.Background(), dir)
+}
+
+// DownloadContext is like Download but gives up when ctx is done.
+func (v *Cmd) DownloadContext(ctx context.Context, dir string) error {
+	return v.runContext(ctx, dir, v.downloadCmd)
 }
 
 // Tags returns the list of available tags for the repo in dir.
 func (v *Cmd) Tags(dir string) ([]string, error) {
+	return v.TagsContext(context.Background(), dir)
+}
+
+// TagsContext is like Tags but gives up when ctx is done.
+func (v *Cmd) TagsContext(ctx context.Context, dir string) ([]string, error) {
 	var tags []string
 	for _, tc := range v.tagCmd {
-		out, err := v.runOutput(dir, tc.cmd)
+		out, err := v.runOutputContext(ctx, dir, tc.cmd)
 		if err != nil {
 			return nil, err
 		}
@@ -233,13 +278,13 @@ func (v *Cmd) Tags(dir string) ([]string, error) {
 
 // tagSync syncs the repo in dir to the named tag,
 // which either is a tag returned by tags or is v.tagDefault.
-func (v *Cmd) tagSync(dir, tag string) error {
+func (v *Cmd) tagSync(ctx context.Context, dir, tag string) error {
 	if v.tagSyncCmd == "" {
 		return nil
 	}
 	if tag != "" {
 		for _, tc := range v.tagLookupCmd {
-			out, err := v.runOutput(dir, tc.cmd, "tag", tag)
+			out, err := v.runOutputContext(ctx, dir, tc.cmd, "tag", tag)
 			if err != nil {
 				return err
 			}
@@ -252,9 +297,9 @@ func (v *Cmd) tagSync(dir, tag string) error {
 		}
 	}
 	if tag == "" && v.tagSyncDefault != "" {
-		return v.run(dir, v.tagSyncDefault)
+		return v.runContext(ctx, dir, v.tagSyncDefault)
 	}
-	return v.run(dir, v.tagSyncCmd, "tag", tag)
+	return v.runContext(ctx, dir, v.tagSyncCmd, "tag", tag)
 }
 
 // expand rewrites s to replace {k} with match[k] for each key k in match.
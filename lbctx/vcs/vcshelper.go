@@ -3,15 +3,18 @@ package vcs
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 )
 
 func path_exists(name string) bool {
@@ -25,23 +28,27 @@ func path_exists(name string) bool {
 	return false
 }
 
+// Run runs the command line cmd of vcs in the current directory and returns
+// its output. Failures are reported as *Error.
 func Run(vcs *Cmd, cmd string, keyval ...string) ([]byte, error) {
-	const verbose = true
+	return RunContext(context.Background(), vcs, cmd, keyval...)
+}
+
+// RunContext is like Run but the command is killed if ctx is done before it
+// completes.
+func RunContext(ctx context.Context, vcs *Cmd, cmd string, keyval ...string) ([]byte, error) {
 	const dir = "."
-	return vcs.run1(dir, cmd, keyval, verbose)
+	return vcs.run1Context(ctx, dir, cmd, keyval)
 }
 
 func Command(vcs *Cmd, cmdline string, keyval ...string) *exec.Cmd {
-	m := make(map[string]string)
-	for i := 0; i < len(keyval); i += 2 {
-		m[keyval[i]] = keyval[i+1]
-	}
-	args := strings.Fields(cmdline)
-	for i, arg := range args {
-		args[i] = expand(m, arg)
-	}
+	return CommandContext(context.Background(), vcs, cmdline, keyval...)
+}
 
-	return exec.Command(vcs.cmd, args...)
+// CommandContext is like Command but the command is killed if ctx is done
+// before it completes.
+func CommandContext(ctx context.Context, vcs *Cmd, cmdline string, keyval ...string) *exec.Cmd {
+	return vcs.command(ctx, cmdline, keyval)
 }
 
 type Helper struct {
@@ -58,7 +65,13 @@ type Helper struct {
 }
 
 func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
-	var err error
+	return NewHelperContext(context.Background(), pkguri, pkgname, pkgid, pkgdir)
+}
+
+// NewHelperContext is like NewHelper but the version control commands are
+// killed if ctx is done before they complete.
+// The temporary directory of the helper is removed on failure.
+func NewHelperContext(ctx context.Context, pkguri, pkgname, pkgid, pkgdir string) (h *Helper, err error) {
 
 	pkguri = os.ExpandEnv(pkguri)
 
@@ -76,6 +89,12 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 		return nil, err
 	}
 
+	// file:///path is handled as a local path
+	if uri.Scheme == "file" {
+		uri.Scheme = ""
+		pkguri = uri.Path
+	}
+
 	// FIXME: hack. we need a better "plugin architecture" for this...
 	if uri.Scheme == "" {
 		if !path_exists(uri.Path) {
@@ -89,12 +108,21 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 			}
 		} else {
 			// check whether this is a local xyz-repo
+			abspath, err := filepath.Abs(uri.Path)
+			if err != nil {
+				return nil, err
+			}
 			for _, vcs := range List {
-				if vcs.Ping("file", uri.Path) == nil {
+				if vcs.PingContext(ctx, "file", abspath) == nil {
 					uri.Scheme = vcs.cmd
+					uri.Path = abspath
+					pkguri = "file://" + abspath
 					break
 				}
 			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
 			if uri.Scheme == "" {
 				uri.Scheme = "local"
 			}
@@ -110,8 +138,13 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmpdir)
+		}
+	}()
 
-	h := &Helper{
+	h = &Helper{
 		Uri:     uri,
 		TmpDir:  tmpdir,
 		PkgUri:  pkguri,
@@ -151,12 +184,13 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 		}
 		h.Type = "svn"
 		h.Repo = repo
-		err = Svn.Create(tmpdir, repo)
+		err = Svn.CreateContext(ctx, tmpdir, repo)
 		if err != nil {
 			return nil, err
 		}
 
-		bout, err := Svn.runOutput(tmpdir, "info")
+		var bout []byte
+		bout, err = Svn.runOutputContext(ctx, tmpdir, "info")
 		if err != nil {
 			return nil, err
 		}
@@ -201,8 +235,13 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 
 	case "git", "git+ssh", "git+kerberos":
 
-		h.PkgName = strings.Join(strings.Split(uri.Path, "/")[3:], "/")
-		repo := pkguri[:len(pkguri)-len(h.PkgName)]
+		// a local repository is checked out as a whole.
+		repo := pkguri
+		h.PkgName = ""
+		if uri.Host != "" || !path_exists(uri.Path) {
+			h.PkgName = strings.Join(strings.Split(uri.Path, "/")[3:], "/")
+			repo = pkguri[:len(pkguri)-len(h.PkgName)]
+		}
 		if strings.HasSuffix(repo, "/") {
 			repo = repo[:len(repo)-1]
 		}
@@ -232,6 +271,25 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 		// fmt.Printf("uri: %q\n", pkguri)
 		// fmt.Printf("url: %q\n", pkgurl)
 
+	case "hg", "hg+ssh", "bzr", "bzr+ssh":
+		vcs := Hg
+		if strings.HasPrefix(uri.Scheme, "bzr") {
+			vcs = Bzr
+		}
+		h.Type = vcs.cmd
+		h.Repo = dvcs_url(uri)
+		h.PkgName = path.Base(strings.TrimSuffix(uri.Path, "/"))
+
+		tag := pkgid
+		switch tag {
+		case "head", "trunk", "tip":
+			tag = ""
+		}
+		err = dvcs_create(ctx, vcs, tmpdir, h.Repo, tag, h.PkgName)
+		if err != nil {
+			return nil, err
+		}
+
 	default:
 		return nil, fmt.Errorf("unknown URL scheme [%v]", uri.Scheme)
 	}
@@ -269,6 +327,12 @@ func NewHelper(pkguri, pkgname, pkgid, pkgdir string) (*Helper, error) {
 }
 
 func (h *Helper) Checkout() error {
+	return h.CheckoutContext(context.Background())
+}
+
+// CheckoutContext is like Checkout but the version control commands are
+// killed if ctx is done before they complete.
+func (h *Helper) CheckoutContext(ctx context.Context) error {
 	var err error
 	switch h.Type {
 	default:
@@ -285,12 +349,83 @@ func (h *Helper) Checkout() error {
 		}
 		err = copytree(pkgdir, h.TmpDir)
 	case "git":
-		err = h.git_checkout()
+		unlock := lock_repo(h.RepoDir)
+		err = h.git_checkout(ctx)
+		unlock()
+	}
+	return err
+}
+
+// repo_locks serializes the git operations on a repository, which update its
+// .git/info/sparse-checkout file and working tree.
+var repo_locks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// lock_repo locks the repository in dir and returns the function unlocking it.
+func lock_repo(dir string) func() {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	repo_locks.Lock()
+	mu, ok := repo_locks.m[dir]
+	if !ok {
+		mu = new(sync.Mutex)
+		repo_locks.m[dir] = mu
+	}
+	repo_locks.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// dvcs_url returns the URL of the hg or bzr repository at uri, as understood
+// by the hg and bzr commands: local repositories are given by their path,
+// hg+ssh:// and hg:// URIs are turned into ssh:// and https:// URLs.
+func dvcs_url(uri *url.URL) string {
+	u := *uri
+	switch {
+	case u.Host == "":
+		return u.Path
+	case u.Scheme == "hg+ssh":
+		u.Scheme = "ssh"
+	case u.Scheme == "hg":
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// dvcs_create clones the hg or bzr repository repo into dir and syncs it to
+// tag (the default branch if empty). The version.lbx file of the clone holds
+// the tag, or name-<revision>.
+func dvcs_create(ctx context.Context, vcs *Cmd, dir, repo, tag, name string) error {
+	// hg and bzr create the destination directory themselves.
+	err := os.Remove(dir)
+	if err != nil {
+		return err
+	}
+
+	err = vcs.CreateContext(ctx, dir, repo)
+	if err != nil {
+		return err
+	}
+
+	err = vcs.tagSync(ctx, dir, tag)
+	if err != nil {
+		return err
+	}
+
+	// retrieve tag/version infos
+	rev := tag
+	if rev == "" {
+		bout, err := vcs.runOutputContext(ctx, dir, vcs.revCmd)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "**err-checkout** (%s): %v\n", h.PkgUri, err)
+			return err
 		}
+		rev = name + "-" + strings.TrimSpace(string(bout))
 	}
-	return err
+	return ioutil.WriteFile(filepath.Join(dir, "version.lbx"), []byte(rev+"\n"), 0666)
 }
 
 func (h *Helper) Delete() error {
@@ -302,7 +437,7 @@ func (h *Helper) Delete() error {
 	}
 }
 
-func (h *Helper) git_checkout() error {
+func (h *Helper) git_checkout(ctx context.Context) error {
 
 	var err error
 	repo_name := filepath.Base(h.RepoDir)
@@ -316,12 +451,12 @@ func (h *Helper) git_checkout() error {
 	sparse_fname := filepath.Join(h.RepoDir, ".git", "info", "sparse-checkout")
 
 	if !path_exists(h.RepoDir) {
-		err = Git.run(h.PkgDir, "init {repo}", "repo", repo_name)
+		err = Git.runContext(ctx, h.PkgDir, "init {repo}", "repo", repo_name)
 		if err != nil {
 			return err
 		}
 
-		err = Git.run(h.RepoDir, "remote add origin {origin}", "origin", h.Repo)
+		err = Git.runContext(ctx, h.RepoDir, "remote add origin {origin}", "origin", h.Repo)
 		if err != nil {
 			return err
 		}
@@ -355,13 +490,13 @@ func (h *Helper) git_checkout() error {
 		}
 	}
 
-	err = Git.run(h.RepoDir, "remote update origin")
+	err = Git.runContext(ctx, h.RepoDir, "remote update origin")
 	if err != nil {
 		return err
 	}
 
 	if do_sparse {
-		err = Git.run(h.RepoDir, "config core.sparsecheckout true")
+		err = Git.runContext(ctx, h.RepoDir, "config core.sparsecheckout true")
 		if err != nil {
 			return err
 		}
@@ -374,22 +509,26 @@ func (h *Helper) git_checkout() error {
 		}
 	}
 
-	err = Git.run(h.RepoDir, "checkout {tag}", "tag", h.PkgId)
+	err = Git.runContext(ctx, h.RepoDir, "checkout {tag}", "tag", h.PkgId)
 	if err != nil {
 		return err
 	}
 
-	err = Git.run(h.RepoDir, "read-tree -mu {tag}", "tag", h.PkgId)
+	err = Git.runContext(ctx, h.RepoDir, "read-tree -mu {tag}", "tag", h.PkgId)
 	if err != nil {
 		return err
 	}
 
 	// retrieve tag/version infos
-	bout, err := Git.runOutput(h.RepoDir, "rev-parse --short HEAD")
+	bout, err := Git.runOutputContext(ctx, h.RepoDir, Git.revCmd)
 	if err != nil {
 		return err
 	}
-	rev := h.PkgName + "-" + string(bout)
+	name := h.PkgName
+	if name == "" {
+		name = repo_name
+	}
+	rev := name + "-" + string(bout)
 	err = ioutil.WriteFile(filepath.Join(h.RepoDir, "version.lbx"), []byte(rev), 0666)
 	if err != nil {
 		return err
@@ -408,11 +547,49 @@ func git_add_sparse_checkout(h *Helper) error {
 		)
 	}
 
-	content, err := ioutil.ReadFile(sparse)
+	paths, err := git_read_sparse_checkout(sparse)
 	if err != nil {
 		return err
 	}
 
+	pkgname := h.PkgName + "/"
+	//fmt.Printf(">> adding [%s]...\n", pkgname)
+	if _, ok := paths[pkgname]; ok {
+		// already in sparse-checkout selection
+		return nil
+	}
+
+	paths[pkgname] = struct{}{}
+	return git_write_sparse_checkout(sparse, paths)
+}
+
+// git_remove_sparse_checkout removes pkgname from the sparse-checkout file
+// and returns the number of remaining entries.
+func git_remove_sparse_checkout(sparse, pkgname string) (int, error) {
+	paths, err := git_read_sparse_checkout(sparse)
+	if err != nil {
+		return 0, err
+	}
+
+	pkgname = strings.TrimSuffix(pkgname, "/") + "/"
+	if _, ok := paths[pkgname]; !ok {
+		return len(paths), fmt.Errorf(
+			"vcs.git: package [%s] not in sparse-checkout file [%s]",
+			pkgname, sparse,
+		)
+	}
+	delete(paths, pkgname)
+
+	return len(paths), git_write_sparse_checkout(sparse, paths)
+}
+
+// git_read_sparse_checkout returns the set of entries of a sparse-checkout file.
+func git_read_sparse_checkout(sparse string) (map[string]struct{}, error) {
+	content, err := ioutil.ReadFile(sparse)
+	if err != nil {
+		return nil, err
+	}
+
 	paths := make(map[string]struct{})
 	for _, line := range bytes.Split(content, []byte("\n")) {
 		text := strings.Trim(string(line), " \r\n\t")
@@ -421,15 +598,13 @@ func git_add_sparse_checkout(h *Helper) error {
 		}
 		paths[text] = struct{}{}
 	}
+	return paths, nil
+}
 
-	pkgname := h.PkgName + "/"
-	//fmt.Printf(">> adding [%s]...\n", pkgname)
-	if _, ok := paths[pkgname]; ok {
-		// already in sparse-checkout selection
-		return nil
-	}
-
-	paths[pkgname] = struct{}{}
+// git_write_sparse_checkout atomically replaces the sparse-checkout file with
+// the sorted list of paths.
+func git_write_sparse_checkout(sparse string, paths map[string]struct{}) error {
+	var err error
 
 	pkgs := make([]string, 0, len(paths))
 	for pkg := range paths {
@@ -437,16 +612,13 @@ func git_add_sparse_checkout(h *Helper) error {
 	}
 	sort.Strings(pkgs)
 
-	err = os.Rename(sparse, sparse+"-old")
-	if err != nil {
-		return nil
-	}
-
-	f, err := os.Create(sparse + "-new")
+	f, err := ioutil.TempFile(filepath.Dir(sparse), "sparse-checkout-")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
+
 	for _, pkg := range pkgs {
 		_, err = f.WriteString(pkg + "\n")
 		if err != nil {
@@ -457,7 +629,11 @@ func git_add_sparse_checkout(h *Helper) error {
 	if err != nil {
 		return err
 	}
-	err = os.Rename(sparse+"-new", sparse)
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), sparse)
 	return err
 }
 
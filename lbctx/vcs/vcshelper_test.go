@@ -0,0 +1,195 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHelperCheckoutConcurrent(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-vcs-helper-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	pkgs := []string{"Hat/A", "Hat/B", "Hat/C", "Hat/D", "Hat/E", "Hat/F"}
+
+	upstream := filepath.Join(top, "upstream")
+	for _, pkg := range pkgs {
+		cmake := filepath.Join(upstream, pkg, "CMakeLists.txt")
+		err = os.MkdirAll(filepath.Dir(cmake), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		err = ioutil.WriteFile(cmake, []byte(pkg+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	git(t, upstream, "init", "-q")
+	git(t, upstream, "checkout", "-q", "-b", "master")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "initial import")
+
+	work := filepath.Join(top, "work")
+	errs := make(chan error, len(pkgs))
+	for _, pkg := range pkgs {
+		go func(pkg string) {
+			h := &Helper{
+				Type:    "git",
+				Repo:    upstream,
+				RepoDir: filepath.Join(work, "Repo"),
+				PkgName: pkg,
+				PkgId:   "master",
+				PkgDir:  work,
+			}
+			errs <- h.Checkout()
+		}(pkg)
+	}
+	for _ = range pkgs {
+		if err := <-errs; err != nil {
+			t.Fatalf("error checking out: %v", err)
+		}
+	}
+
+	sparse, err := git_read_sparse_checkout(filepath.Join(work, "Repo", ".git", "info", "sparse-checkout"))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, pkg := range pkgs {
+		if _, ok := sparse[pkg+"/"]; !ok {
+			t.Errorf("expected [%s] in the sparse-checkout file. got=%v", pkg, sparse)
+		}
+		if !path_exists(filepath.Join(work, "Repo", pkg, "CMakeLists.txt")) {
+			t.Errorf("expected [%s] to be checked out", pkg)
+		}
+	}
+}
+
+func TestDvcsURL(t *testing.T) {
+	for _, table := range []struct {
+		uri  string
+		want string
+	}{
+		{"hg+ssh://hg@example.com/ext/Pkg", "ssh://hg@example.com/ext/Pkg"},
+		{"hg://example.com/ext/Pkg", "https://example.com/ext/Pkg"},
+		{"hg:///data/repos/Pkg", "/data/repos/Pkg"},
+		{"bzr+ssh://example.com/ext/Pkg", "bzr+ssh://example.com/ext/Pkg"},
+		{"bzr://example.com/ext/Pkg", "bzr://example.com/ext/Pkg"},
+	} {
+		uri, err := url.Parse(table.uri)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if got := dvcs_url(uri); got != table.want {
+			t.Errorf("%s: expected %q. got=%q", table.uri, table.want, got)
+		}
+	}
+}
+
+func TestHelperCheckoutDvcs(t *testing.T) {
+	for _, table := range []struct {
+		vcs   string
+		setup [][]string
+	}{
+		{
+			vcs: "hg",
+			setup: [][]string{
+				{"init"},
+				{"add", "CMakeLists.txt"},
+				{"commit", "-m", "v1"},
+				{"tag", "v1r0"},
+			},
+		},
+		{
+			vcs: "bzr",
+			setup: [][]string{
+				{"init"},
+				{"add", "CMakeLists.txt"},
+				{"commit", "-m", "v1"},
+				{"tag", "v1r0"},
+			},
+		},
+	} {
+		if _, err := exec.LookPath(table.vcs); err != nil {
+			t.Logf("%s not available", table.vcs)
+			continue
+		}
+
+		top, err := ioutil.TempDir("", "lbx-vcs-helper-")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer os.RemoveAll(top)
+
+		repo := filepath.Join(top, "Pkg")
+		err = os.MkdirAll(repo, 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		cmake := filepath.Join(repo, "CMakeLists.txt")
+		err = ioutil.WriteFile(cmake, []byte("v1\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		run := func(args ...string) {
+			cmd := exec.Command(table.vcs, args...)
+			cmd.Dir = repo
+			cmd.Env = append(os.Environ(), "HGUSER=lbx", "BZR_EMAIL=lbx <lbx@example.com>")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%s %v: %v\n%s", table.vcs, args, err, string(out))
+			}
+		}
+		for _, args := range table.setup {
+			run(args...)
+		}
+		err = ioutil.WriteFile(cmake, []byte("v2\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		run("commit", "-m", "v2")
+
+		for _, tag := range []string{"", "v1r0"} {
+			work := filepath.Join(top, "work-"+tag)
+			h, err := NewHelper(table.vcs+"://"+repo, "", tag, work)
+			if err != nil {
+				t.Fatalf("%s: error creating helper: %v", table.vcs, err)
+			}
+			err = h.Checkout()
+			h.Delete()
+			if err != nil {
+				t.Fatalf("%s: error checking out: %v", table.vcs, err)
+			}
+			if h.Type != table.vcs {
+				t.Errorf("%s: expected a %s helper. got=%q", table.vcs, table.vcs, h.Type)
+			}
+
+			want := map[string]string{"": "v2\n", "v1r0": "v1\n"}[tag]
+			content, err := ioutil.ReadFile(filepath.Join(work, "Pkg", "CMakeLists.txt"))
+			if err != nil || string(content) != want {
+				t.Errorf("%s [%s]: expected %q. got=%q (err=%v)", table.vcs, tag, want, string(content), err)
+			}
+
+			stamp, err := ioutil.ReadFile(filepath.Join(work, "Pkg", "version.lbx"))
+			if err != nil {
+				t.Errorf("%s [%s]: expected a version.lbx file: %v", table.vcs, tag, err)
+			}
+			prefix := tag
+			if tag == "" {
+				prefix = "Pkg-"
+			}
+			if !strings.HasPrefix(string(stamp), prefix) {
+				t.Errorf("%s [%s]: expected version.lbx to start with %q. got=%q", table.vcs, tag, prefix, string(stamp))
+			}
+		}
+	}
+}
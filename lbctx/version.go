@@ -0,0 +1,138 @@
+package lbctx
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// g_lhcbvers matches LHCb-style versions: vXrY, vXrYpZ, vXrYgN and vXrYpZgN
+var g_lhcbvers = regexp.MustCompile(`^v(\d+)r(\d+)(?:p(\d+))?(?:g(\d+))?$`)
+
+// g_numvers matches numeric versions: X, X.Y, X.Y.Z, ...
+var g_numvers = regexp.MustCompile(`^\d+(?:\.\d+)*$`)
+
+type versionKind int
+
+const (
+	branchVersion versionKind = iota // branch-like names (e.g. "trunk", "dev")
+	numberVersion                    // vXrY[pZ][gN] or X.Y.Z
+	headVersion                      // HEAD
+)
+
+// Version is the version of a project or package.
+//
+// Versions are ordered as follows:
+//   - branch-like names sort first, in lexicographical order,
+//   - then LHCb-style (vXrY[pZ][gN]) and numeric (X.Y.Z) versions, ordered
+//     numerically, missing components counting as 0,
+//   - then HEAD.
+type Version struct {
+	str  string
+	kind versionKind
+	nums []int
+}
+
+// ParseVersion parses a version string.
+// Strings which are not recognized as a numbered version or HEAD are
+// considered branch names.
+func ParseVersion(str string) Version {
+	v := Version{str: str, kind: branchVersion}
+	switch {
+	case strings.ToUpper(str) == "HEAD":
+		v.kind = headVersion
+
+	case g_lhcbvers.MatchString(str):
+		v.kind = numberVersion
+		m := g_lhcbvers.FindStringSubmatch(str)
+		v.nums = atois(m[1:])
+
+	case g_numvers.MatchString(str):
+		v.kind = numberVersion
+		v.nums = atois(strings.Split(str, "."))
+	}
+	return v
+}
+
+// atois converts a list of decimal strings to ints. Empty strings are 0.
+func atois(strs []string) []int {
+	nums := make([]int, len(strs))
+	for i, str := range strs {
+		if str == "" {
+			continue
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			// can only overflow: saturate.
+			n = int(^uint(0) >> 1)
+		}
+		nums[i] = n
+	}
+	return nums
+}
+
+// String returns the original version string.
+func (v Version) String() string {
+	return v.str
+}
+
+// IsRelease returns whether v is a numbered (LHCb-style or numeric) version.
+func (v Version) IsRelease() bool {
+	return v.kind == numberVersion
+}
+
+// IsHead returns whether v is the HEAD version.
+func (v Version) IsHead() bool {
+	return v.kind == headVersion
+}
+
+// Compare returns -1, 0 or +1 depending on whether v is older than, the same
+// as or newer than o.
+func (v Version) Compare(o Version) int {
+	if v.kind != o.kind {
+		if v.kind < o.kind {
+			return -1
+		}
+		return +1
+	}
+
+	switch v.kind {
+	case numberVersion:
+		n := len(v.nums)
+		if len(o.nums) > n {
+			n = len(o.nums)
+		}
+		for i := 0; i < n; i++ {
+			vi, oi := 0, 0
+			if i < len(v.nums) {
+				vi = v.nums[i]
+			}
+			if i < len(o.nums) {
+				oi = o.nums[i]
+			}
+			switch {
+			case vi < oi:
+				return -1
+			case vi > oi:
+				return +1
+			}
+		}
+		return 0
+
+	case branchVersion:
+		return strings.Compare(v.str, o.str)
+	}
+	return 0
+}
+
+// Less returns whether v is older than o.
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+// Versions is a list of versions implementing sort.Interface
+type Versions []Version
+
+func (p Versions) Len() int           { return len(p) }
+func (p Versions) Less(i, j int) bool { return p[i].Less(p[j]) }
+func (p Versions) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
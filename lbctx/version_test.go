@@ -0,0 +1,99 @@
+package lbctx
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, table := range []struct {
+		str     string
+		release bool
+		head    bool
+		nums    []int
+	}{
+		{"v1r0", true, false, []int{1, 0, 0, 0}},
+		{"v25r2", true, false, []int{25, 2, 0, 0}},
+		{"v34r1p3", true, false, []int{34, 1, 3, 0}},
+		{"v8r1g2", true, false, []int{8, 1, 0, 2}},
+		{"v8r1p1g2", true, false, []int{8, 1, 1, 2}},
+		{"1.2.3", true, false, []int{1, 2, 3}},
+		{"42", true, false, []int{42}},
+		{"HEAD", false, true, nil},
+		{"head", false, true, nil},
+		{"trunk", false, false, nil},
+		{"master", false, false, nil},
+		{"v1r", false, false, nil},
+		{"v1r2-patches", false, false, nil},
+		{"1.2.", false, false, nil},
+	} {
+		v := ParseVersion(table.str)
+		if v.String() != table.str {
+			t.Errorf("%q: invalid string. got=%q", table.str, v.String())
+		}
+		if v.IsRelease() != table.release {
+			t.Errorf("%q: expected release=%v. got=%v", table.str, table.release, v.IsRelease())
+		}
+		if v.IsHead() != table.head {
+			t.Errorf("%q: expected head=%v. got=%v", table.str, table.head, v.IsHead())
+		}
+		if !reflect.DeepEqual(v.nums, table.nums) {
+			t.Errorf("%q: expected nums=%v. got=%v", table.str, table.nums, v.nums)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	for _, table := range []struct {
+		a, b string
+		cmp  int
+	}{
+		{"v9r3", "v10r1", -1},
+		{"v10r1", "v9r3", +1},
+		{"v10r1", "v10r1p0", 0},
+		{"v10r1", "v10r1p1", -1},
+		{"v10r1p10", "v10r1p9", +1},
+		{"v10r1p1", "v10r1g1", +1},
+		{"v10r1g2", "v10r1g10", -1},
+		{"v1r0", "1.0", 0},
+		{"1.10", "1.9.9", +1},
+		{"v99r99", "HEAD", -1},
+		{"HEAD", "head", 0},
+		{"trunk", "v1r0", -1},
+		{"master", "dev", +1},
+		{"master", "HEAD", -1},
+	} {
+		a := ParseVersion(table.a)
+		b := ParseVersion(table.b)
+		if cmp := a.Compare(b); cmp != table.cmp {
+			t.Errorf("compare(%q, %q): expected %d. got=%d", table.a, table.b, table.cmp, cmp)
+		}
+		if cmp := b.Compare(a); cmp != -table.cmp {
+			t.Errorf("compare(%q, %q): expected %d. got=%d", table.b, table.a, -table.cmp, cmp)
+		}
+	}
+}
+
+func TestVersionsSort(t *testing.T) {
+	want := []string{
+		"dev", "master",
+		"v8r1", "v9r3", "v9r3p1", "v10r0", "v10r1g1", "v10r1p1", "v10r1p2", "v34r1",
+		"HEAD",
+	}
+
+	versions := make(Versions, len(want))
+	for i, j := range rand.Perm(len(want)) {
+		versions[i] = ParseVersion(want[j])
+	}
+	sort.Sort(versions)
+
+	got := make([]string, len(versions))
+	for i, v := range versions {
+		got[i] = v.String()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v. got=%v", want, got)
+	}
+}
@@ -0,0 +1,45 @@
+package lbctx
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WorkAreaPackages returns the directories (relative to top) of the packages
+// checked out in the work area top.
+// A package is a directory holding a CMakeLists.txt or a cmt/requirements
+// file. Hidden directories, build directories and InstallArea are skipped.
+func WorkAreaPackages(top string) ([]string, error) {
+	var pkgs []string
+	err := filepath.Walk(top, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if path == top {
+			return nil
+		}
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "build") || name == "InstallArea" {
+			return filepath.SkipDir
+		}
+		for _, fname := range []string{"CMakeLists.txt", filepath.Join("cmt", "requirements")} {
+			if _, err := os.Stat(filepath.Join(path, fname)); err == nil {
+				rel, err := filepath.Rel(top, path)
+				if err != nil {
+					return err
+				}
+				pkgs = append(pkgs, rel)
+				// do not look for sub-packages.
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	sort.Strings(pkgs)
+	return pkgs, err
+}
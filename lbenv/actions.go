@@ -1,5 +1,7 @@
 package lbenv
 
+import "fmt"
+
 type VarType int
 
 const (
@@ -14,100 +16,186 @@ func (vt VarType) String() string {
 	case VarScalar:
 		return "scalar"
 	}
-	panic("unreachable")
+	return fmt.Sprintf("VarType(%d)", int(vt))
 }
 
+// Action is a modification of an Environment.
+// Run applies the action to env, usually through the methods of env (Set,
+// Append, ...) so the action is recorded on the stack of env.
+// Actions defined outside of this package may be loaded with Replay: they are
+// recorded as the actions they apply.
 type Action interface {
 	Run(env *Environment) error
 }
 
+// Sourced is implemented by the actions knowing where they were defined.
+type Sourced interface {
+	Source() *Source
+}
+
 type DeclareVar struct {
 	Name  string
 	Local bool
 	Type  VarType
+	From  *Source // where the action was defined, if known
 }
 
 func (v *DeclareVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Declare(v.Name, v.Type, v.Local)
+}
+
+func (v *DeclareVar) Source() *Source {
+	return v.From
+}
+
+func (v *DeclareVar) String() string {
+	local := ""
+	if v.Local {
+		local = "local "
+	}
+	return fmt.Sprintf("declare %s%s %s", local, v.Type, v.Name)
 }
 
 type SetVar struct {
 	Name  string
 	Value string
+	From  *Source // where the action was defined, if known
 }
 
 func (v *SetVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Set(v.Name, v.Value)
+}
+
+func (v *SetVar) Source() *Source {
+	return v.From
+}
+
+func (v *SetVar) String() string {
+	return fmt.Sprintf("set %s=%q", v.Name, v.Value)
 }
 
 type DefaultVar struct {
 	Name  string
 	Value string
+	From  *Source // where the action was defined, if known
 }
 
 func (v *DefaultVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Set(v.Name, v.Value)
+}
+
+func (v *DefaultVar) Source() *Source {
+	return v.From
+}
+
+func (v *DefaultVar) String() string {
+	return fmt.Sprintf("default %s=%q", v.Name, v.Value)
 }
 
 type UnsetVar struct {
 	Name string
+	From *Source // where the action was defined, if known
 }
 
 func (v *UnsetVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Unset(v.Name)
+}
+
+func (v *UnsetVar) Source() *Source {
+	return v.From
+}
+
+func (v *UnsetVar) String() string {
+	return fmt.Sprintf("unset %s", v.Name)
 }
 
 type AppendVar struct {
 	Name  string
 	Value string
+	From  *Source // where the action was defined, if known
 }
 
 func (v *AppendVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Append(v.Name, v.Value)
+}
+
+func (v *AppendVar) Source() *Source {
+	return v.From
+}
+
+func (v *AppendVar) String() string {
+	return fmt.Sprintf("append %q to %s", v.Value, v.Name)
 }
 
 type PrependVar struct {
 	Name  string
 	Value string
+	From  *Source // where the action was defined, if known
 }
 
 func (v *PrependVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Prepend(v.Name, v.Value)
+}
+
+func (v *PrependVar) Source() *Source {
+	return v.From
+}
+
+func (v *PrependVar) String() string {
+	return fmt.Sprintf("prepend %q to %s", v.Value, v.Name)
 }
 
 type RemoveVar struct {
 	Name  string
 	Value string
+	From  *Source // where the action was defined, if known
 }
 
 func (v *RemoveVar) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Remove(v.Name, v.Value)
+}
+
+func (v *RemoveVar) Source() *Source {
+	return v.From
+}
+
+func (v *RemoveVar) String() string {
+	return fmt.Sprintf("remove %q from %s", v.Value, v.Name)
 }
 
 type RemoveRegexp struct {
 	Name  string
 	Value string
+	From  *Source // where the action was defined, if known
 }
 
 func (v *RemoveRegexp) Run(env *Environment) error {
-	var err error
-	return err
+	return env.RemoveRegexp(v.Name, v.Value)
+}
+
+func (v *RemoveRegexp) Source() *Source {
+	return v.From
+}
+
+func (v *RemoveRegexp) String() string {
+	return fmt.Sprintf("remove-regexp %q from %s", v.Value, v.Name)
 }
 
 type Include struct {
 	File   string `xml:"include"`
 	Caller string
-	Hints  string `xml:"hints,attr"`
+	Hints  string  `xml:"hints,attr"`
+	From   *Source // where the action was defined, if known
 }
 
 func (v *Include) Run(env *Environment) error {
-	var err error
-	return err
+	return env.Include(v.File, v.Caller, v.Hints)
+}
+
+func (v *Include) Source() *Source {
+	return v.From
+}
+
+func (v *Include) String() string {
+	return fmt.Sprintf("include %s", v.File)
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 )
 
 // default search path for the environment XML files
@@ -19,21 +20,31 @@ func init() {
 	}
 }
 
-// ShellType is the type of the shell (sh,csh,bat)
+// ShellType is the type of the shell (sh,csh,bat,fish) or output format (json)
 type ShellType int
 
 const (
-	ShType  ShellType = iota // Sh-shell (bash, sh, dash, zsh, ...)
-	CshType                  // C-Shell (csh, tcsh)
-	BatType                  // BAT (windows)
+	ShType   ShellType = iota // Sh-shell (bash, sh, dash, zsh, ...)
+	CshType                   // C-Shell (csh, tcsh)
+	BatType                   // BAT (windows)
+	FishType                  // fish shell
+	JSONType                  // JSON document
 )
 
 // Environment models the recipe(s) to craft and obtain a given environment
 type Environment struct {
-	LoadFromSystem bool        // whether to load values from system
-	SearchPath     []string    // search paths for XML files (used by 'include' elements)
-	Processors     []Processor // list of processors to massage env.vars.
+	LoadFromSystem bool           // whether to load values from system
+	System         []string       // system environment as key=value pairs (os.Environ() if nil)
+	SearchPath     []string       // search paths for XML files (used by 'include' elements)
+	Processors     []Processor    // list of processors to massage env.vars.
+	Strict         bool           // report unknown XML elements and attributes as errors
+	Warnings       []*DecodeError // problems skipped while loading XML files (lenient mode)
+	Skipped        []string       // variables left out of the last generated shell script (invalid names)
 	stack          []Action
+	history        []Step   // actions applied to the environment, with their provenance
+	src            *Source  // provenance of the action being loaded
+	changes        []Change // changes of the processors during the current action
+	files          []string // stack of the XML files being loaded
 	vars           map[string]Var
 	loaded         map[string]struct{} // set of XML env files already 'included'
 	dirstack       []string            // stack of files being processed
@@ -46,6 +57,7 @@ func New() *Environment {
 		SearchPath:     make([]string, 0),
 		Processors:     defaultProcessors(),
 		stack:          make([]Action, 0),
+		history:        make([]Step, 0),
 		vars: map[string]Var{
 			".": Var{
 				Name:  ".",
@@ -79,16 +91,17 @@ func (env *Environment) Declare(name string, vtype VarType, local bool) error {
 		Local: local,
 	}
 	if env.LoadFromSystem && !local {
-		v.Value = os.Getenv(name)
+		v.Value = env.getenv(name)
 	}
 	v.set(env.process(&v, v.Value))
 
 	env.vars[name] = v
-	env.stack = append(env.stack, &DeclareVar{
+	env.record(&DeclareVar{
 		Name:  name,
 		Type:  vtype,
 		Local: local,
-	})
+		From:  env.src,
+	}, name)
 	return err
 }
 
@@ -107,10 +120,11 @@ func (env *Environment) Append(name, value string) error {
 	v.append(env.process(&v, value))
 
 	env.vars[name] = v
-	env.stack = append(env.stack, &AppendVar{
+	env.record(&AppendVar{
 		Name:  name,
-		Value: value,
-	})
+		Value: env.expandDot(value),
+		From:  env.src,
+	}, name)
 	return err
 }
 
@@ -129,10 +143,11 @@ func (env *Environment) Prepend(name, value string) error {
 	v.prepend(env.process(&v, value))
 
 	env.vars[name] = v
-	env.stack = append(env.stack, &PrependVar{
+	env.record(&PrependVar{
 		Name:  name,
-		Value: value,
-	})
+		Value: env.expandDot(value),
+		From:  env.src,
+	}, name)
 	return err
 }
 
@@ -152,10 +167,11 @@ func (env *Environment) Set(name, value string) error {
 	v.set(env.process(&v, value))
 
 	env.vars[name] = v
-	env.stack = append(env.stack, &SetVar{
+	env.record(&SetVar{
 		Name:  name,
-		Value: value,
-	})
+		Value: env.expandDot(value),
+		From:  env.src,
+	}, name)
 	return err
 }
 
@@ -168,9 +184,10 @@ func (env *Environment) Unset(name string) error {
 		delete(env.vars, name)
 	}
 
-	env.stack = append(env.stack, &UnsetVar{
+	env.record(&UnsetVar{
 		Name: name,
-	})
+		From: env.src,
+	}, name)
 	return err
 }
 
@@ -189,10 +206,11 @@ func (env *Environment) Remove(name, value string) error {
 	v.remove(env.process(&v, value))
 
 	env.vars[name] = v
-	env.stack = append(env.stack, &RemoveVar{
+	env.record(&RemoveVar{
 		Name:  name,
-		Value: value,
-	})
+		Value: env.expandDot(value),
+		From:  env.src,
+	}, name)
 	return err
 }
 
@@ -215,28 +233,34 @@ func (env *Environment) RemoveRegexp(name, value string) error {
 	v.remove_regexp(re)
 
 	env.vars[name] = v
-	env.stack = append(env.stack, &RemoveRegexp{
+	env.record(&RemoveRegexp{
 		Name:  name,
-		Value: value,
-	})
+		Value: env.expandDot(value),
+		From:  env.src,
+	}, name)
 	return err
 }
 
 // Include includes an XML file's definitions into the environment
 func (env *Environment) Include(fname, caller, hints string) error {
 	var err error
-	/*
-		env.stack = append(env.stack, &Include{
-			File:   fname,
-			Caller: caller,
-			Hints:  hints,
-		})
-	*/
 	fname, err = env.locate(fname, caller, hints)
 	if err != nil {
 		return err
 	}
 
+	// includes are only recorded in the history: the stack already holds
+	// the actions of the included file.
+	env.history = append(env.history, Step{
+		Action: &Include{
+			File:   fname,
+			Caller: caller,
+			Hints:  hints,
+			From:   env.src,
+		},
+		Source: env.src,
+	})
+
 	f, err := os.Open(fname)
 	if err != nil {
 		return err
@@ -309,15 +333,25 @@ func (env *Environment) LoadXML(r io.Reader) error {
 		Type:  VarScalar,
 		Value: filepath.Dir(fname),
 	}
-	actions, err := Decode(r)
+	dec := NewDecoder(r)
+	dec.Strict = env.Strict
+	actions, err := dec.Decode()
+	env.Warnings = append(env.Warnings, dec.Warnings...)
 	if err != nil {
-		if err != io.EOF {
-			return err
-		}
-		err = nil
+		return err
 	}
 
+	includes := make([]string, len(env.files))
+	copy(includes, env.files)
+	env.files = append(env.files, fname)
+	defer func() {
+		env.files = env.files[:len(env.files)-1]
+	}()
+
 	for _, action := range actions {
+		if a, ok := action.(Sourced); ok && a.Source() != nil {
+			a.Source().Includes = includes
+		}
 		err = env.load(action)
 		if err != nil {
 			return err
@@ -327,6 +361,52 @@ func (env *Environment) LoadXML(r io.Reader) error {
 	return err
 }
 
+// Actions returns the actions applied to the environment, in order.
+// The included files are expanded, so the actions may be replayed on another
+// Environment without the XML files.
+func (env *Environment) Actions() []Action {
+	actions := make([]Action, len(env.stack))
+	copy(actions, env.stack)
+	return actions
+}
+
+// Replay applies actions, in order, to the environment.
+// Together with Actions (or SaveXML and LoadXML) and System, Replay re-targets
+// a recorded environment onto another system environment:
+//
+//	batch := lbenv.New()
+//	batch.System = environ
+//	err := batch.Replay(env.Actions())
+func (env *Environment) Replay(actions []Action) error {
+	for _, action := range actions {
+		err := env.load(action)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandDot returns value with ${.} replaced by the directory of the XML file
+// being loaded, so recorded actions replay the same on another Environment.
+func (env *Environment) expandDot(value string) string {
+	return strings.Replace(value, "${.}", env.vars["."].Value, -1)
+}
+
+// getenv returns the value of the variable name in the system environment.
+func (env *Environment) getenv(name string) string {
+	if env.System == nil {
+		return os.Getenv(name)
+	}
+	value := ""
+	for _, kv := range env.System {
+		if i := strings.Index(kv, "="); i > 0 && kv[:i] == name {
+			value = kv[i+1:]
+		}
+	}
+	return value
+}
+
 // LoadXMLByName locates a file by name and runs LoadXML
 func (env *Environment) LoadXMLByName(fname string) error {
 	fname, err := env.locate(fname, "", "")
@@ -342,30 +422,58 @@ func (env *Environment) LoadXMLByName(fname string) error {
 }
 
 // GenScript generates shell script replaying the environment modifications.
-// shell can be 'sh' or 'csh'
+// Local variables are not exported.
+// Variables whose name is not a valid shell identifier are skipped and
+// listed in Skipped.
 func (env *Environment) GenScript(shell ShellType, w io.Writer) error {
+	set := make(map[string]string, len(env.vars))
+	for _, k := range env.Keys() {
+		v := env.Get(k)
+		if v.Local {
+			continue
+		}
+		set[k] = v.Value
+	}
 	var err error
+	env.Skipped, err = writeScript(shell, w, set, nil)
+	return err
+}
 
-	switch shell {
-	case ShType:
-		_, err = fmt.Fprintf(w, "#!/bin/sh\n")
-		if err != nil {
-			return err
+// GenScriptDiff generates a shell script only setting the variables whose
+// value differ from the ones in environ, and unsetting the variables of environ
+// which are not defined in the environment.
+// environ is a list of key=value pairs, as returned by os.Environ.
+// As for GenScript, variables with an invalid name are listed in Skipped.
+func (env *Environment) GenScriptDiff(shell ShellType, w io.Writer, environ []string) error {
+	orig := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
 		}
-		for _, k := range env.Keys() {
-			v := env.Get(k)
-			_, err = fmt.Fprintf(w, "export %s=%q\n", k, v.Value)
-			if err != nil {
-				return err
-			}
+		orig[kv[:i]] = kv[i+1:]
+	}
+
+	set := make(map[string]string)
+	for _, k := range env.Keys() {
+		v := env.Get(k)
+		if v.Local {
+			continue
 		}
-		_, err = fmt.Fprintf(w, "## EOF\n")
-		if err != nil {
-			return err
+		if old, ok := orig[k]; ok && old == v.Value {
+			continue
 		}
-	default:
-		panic(fmt.Errorf("lbenv: unhandled shell type %v\n", shell))
+		set[k] = v.Value
 	}
+
+	unset := make([]string, 0)
+	for k := range orig {
+		if v, ok := env.vars[k]; !ok || v.Local {
+			unset = append(unset, k)
+		}
+	}
+	var err error
+	env.Skipped, err = writeScript(shell, w, set, unset)
 	return err
 }
 
@@ -430,35 +538,29 @@ func (env *Environment) locate(file, caller, hints string) (string, error) {
 // load loads an action into the environment
 func (env *Environment) load(action Action) error {
 	var err error
-	switch a := action.(type) {
-	case *DeclareVar:
-		err = env.Declare(a.Name, a.Type, a.Local)
-	case *DefaultVar:
-		err = env.Set(a.Name, a.Value)
-	case *AppendVar:
-		err = env.Append(a.Name, a.Value)
-	case *PrependVar:
-		err = env.Prepend(a.Name, a.Value)
-	case *SetVar:
-		err = env.Set(a.Name, a.Value)
-	case *UnsetVar:
-		err = env.Unset(a.Name)
-	case *RemoveVar:
-		err = env.Remove(a.Name, a.Value)
-	case *RemoveRegexp:
-		err = env.RemoveRegexp(a.Name, a.Value)
-	case *Include:
-		err = env.Include(a.File, a.Caller, a.Hints)
-	default:
-		panic(fmt.Errorf("lbenv: unknown Action: %[1]v (type=%[1]T)", a))
+	src := env.src
+	defer func() {
+		env.src = src
+	}()
+	env.src = nil
+	if a, ok := action.(Sourced); ok {
+		env.src = a.Source()
 	}
+
+	err = action.Run(env)
 	return err
 }
 
 // process runs all the registered processors on value
+// The changes of the processors are recorded for the history.
 func (env *Environment) process(v *Var, value string) string {
+	env.changes = nil
 	for _, process := range env.Processors {
-		value = process(v, value, env)
+		out := process(v, value, env)
+		if out != value {
+			env.changes = append(env.changes, newChange(process, v, value, out))
+		}
+		value = out
 	}
 	return value
 }
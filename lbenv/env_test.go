@@ -62,7 +62,7 @@ func TestValues(t *testing.T) {
 	}
 	v = splitpath(env.Get("MY_PATH").Value)
 	if !reflect.DeepEqual(v, []string{"hi", "hello"}) {
-		t.Fatalf("expected env=%v. got=%v", "newValue", []string{"hi", "hello"}, v)
+		t.Fatalf("expected env=%v. got=%v", []string{"hi", "hello"}, v)
 	}
 
 	err = env.Unset("MY_PATH")
@@ -212,7 +212,7 @@ func TestGenScript(t *testing.T) {
 	}
 	out := string(bout)
 	exp := `#!/bin/sh
-export sysVar="newValue:lala"
+export sysVar='newValue:lala'
 ## EOF
 `
 	if out != exp {
@@ -428,7 +428,7 @@ func TestDelete(t *testing.T) {
 
 	err = env.Remove("MY_PATH", "anotherVal")
 	if err != nil {
-		t.Fatalf("error: %v")
+		t.Fatalf("error: %v", err)
 	}
 
 	v := env.Get("MY_PATH").Value
@@ -1103,3 +1103,150 @@ func TestFileDir(t *testing.T) {
 	}
 
 }
+
+// upperVar is an Action defined outside of the lbenv actions.
+type upperVar struct {
+	Name string
+}
+
+func (v *upperVar) Run(env *Environment) error {
+	return env.Set(v.Name, strings.ToUpper(env.Get(v.Name).Value))
+}
+
+func TestReplay(t *testing.T) {
+	env := New()
+	env.System = []string{"MY_PATH=/node/a", "MY_NAME=lbx"}
+
+	err := env.Replay([]Action{
+		&PrependVar{Name: "MY_PATH", Value: "/proj/bin"},
+		&DeclareVar{Name: "MY_NAME", Type: VarScalar},
+		&upperVar{Name: "MY_NAME"},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if v, want := env.Get("MY_PATH").Value, "/proj/bin:/node/a"; v != want {
+		t.Fatalf("expected MY_PATH=%q. got=%q", want, v)
+	}
+	if v, want := env.Get("MY_NAME").Value, "LBX"; v != want {
+		t.Fatalf("expected MY_NAME=%q. got=%q", want, v)
+	}
+
+	// re-target the recorded actions to another system environment.
+	batch := New()
+	batch.System = []string{"MY_PATH=/batch/a:/batch/b", "MY_NAME=batch"}
+	err = batch.Replay(env.Actions())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if v, want := batch.Get("MY_PATH").Value, "/proj/bin:/batch/a:/batch/b"; v != want {
+		t.Fatalf("expected MY_PATH=%q. got=%q", want, v)
+	}
+	// upperVar was recorded as the SetVar it applied.
+	if v, want := batch.Get("MY_NAME").Value, "LBX"; v != want {
+		t.Fatalf("expected MY_NAME=%q. got=%q", want, v)
+	}
+
+	// and through the XML recipe.
+	f, err := ioutil.TempFile("", "lbenv-replay-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = batch.SaveXML(f)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	node := New()
+	node.System = []string{"MY_PATH=/node/b", "MY_NAME=node"}
+	err = node.LoadXML(f)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if v, want := node.Get("MY_PATH").Value, "/proj/bin:/node/b"; v != want {
+		t.Fatalf("expected MY_PATH=%q. got=%q", want, v)
+	}
+}
+
+func TestReplayDot(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "lbenv-replay-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fname := filepath.Join(tmpdir, "proj", "Proj.xenv")
+	err = os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(fname, []byte(`<?xml version="1.0" ?>
+<env:config xmlns:env="EnvSchema">
+<env:prepend variable="MY_PATH">${.}/bin</env:prepend>
+<env:set variable="MY_ROOT">${.}</env:set>
+</env:config>
+`), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	env := New()
+	env.System = []string{"MY_PATH=/sys/bin"}
+	err = env.LoadXMLByName(fname)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	dir := filepath.Dir(fname)
+	want := map[string]string{
+		"MY_PATH": filepath.Join(dir, "bin") + ":/node/bin",
+		"MY_ROOT": dir,
+	}
+
+	replay := New()
+	replay.System = []string{"MY_PATH=/node/bin"}
+	err = replay.Replay(env.Actions())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for k, v := range want {
+		if got := replay.Get(k).Value; got != v {
+			t.Errorf("replay: expected %s=%q. got=%q", k, v, got)
+		}
+	}
+
+	// through the XML recipe, saved in another directory.
+	saved := filepath.Join(tmpdir, "saved.xml")
+	f, err := os.Create(saved)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer f.Close()
+	err = env.SaveXML(f)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	node := New()
+	node.System = []string{"MY_PATH=/node/bin"}
+	err = node.LoadXML(f)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for k, v := range want {
+		if got := node.Get(k).Value; got != v {
+			t.Errorf("xml: expected %s=%q. got=%q", k, v, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package lbenv
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// Source is the provenance of an action: the environment XML file and line
+// defining it, and the chain of files which included that file.
+type Source struct {
+	File     string   // name of the XML file
+	Line     int      // line of the action in File (1-based)
+	Includes []string // files including File, outermost first
+}
+
+func (s *Source) String() string {
+	file := s.File
+	if file == "" {
+		file = "<input>"
+	}
+	str := fmt.Sprintf("%s:%d", file, s.Line)
+	if len(s.Includes) > 0 {
+		incs := make([]string, len(s.Includes))
+		for i, inc := range s.Includes {
+			incs[len(incs)-1-i] = inc
+		}
+		str += " (included from " + strings.Join(incs, ", from ") + ")"
+	}
+	return str
+}
+
+// Step records an action applied to an Environment, with its provenance and
+// its effect on the variable it modified.
+type Step struct {
+	Action  Action
+	Source  *Source  // where the action was defined, nil if not loaded from a file
+	Var     string   // name of the modified variable, empty for includes
+	Value   string   // value of the variable after the action
+	Unset   bool     // whether the variable is undefined after the action
+	Changes []Change // changes made by the processors to the value of the action
+}
+
+// Change is a modification made by a Processor to a value.
+type Change struct {
+	Processor string   // name of the processor (e.g. "lbenv.DuplicatesRemover")
+	In        string   // value given to the processor
+	Out       string   // value returned by the processor
+	Dropped   []string // list entries removed by the processor
+}
+
+// History returns the steps which modified the variable name, in order.
+// If name is empty, all the steps are returned, including the inclusions of
+// XML files.
+func (env *Environment) History(name string) []Step {
+	steps := make([]Step, 0)
+	for _, step := range env.history {
+		if name != "" && step.Var != name {
+			continue
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// record pushes action onto the stack, and its effect on the variable name
+// onto the history.
+func (env *Environment) record(action Action, name string) {
+	env.stack = append(env.stack, action)
+	v, ok := env.vars[name]
+	env.history = append(env.history, Step{
+		Action:  action,
+		Source:  env.src,
+		Var:     name,
+		Value:   v.Value,
+		Unset:   !ok,
+		Changes: env.changes,
+	})
+	env.changes = nil
+}
+
+// newChange returns the change of the processor process from in to out.
+func newChange(process Processor, v *Var, in, out string) Change {
+	name := runtime.FuncForPC(reflect.ValueOf(process).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	c := Change{Processor: name, In: in, Out: out}
+	if v.Type != VarList {
+		return c
+	}
+
+	// entries rewritten by a processor (e.g. ExpandVar) are not dropped.
+	ins, outs := splitpath(in), splitpath(out)
+	if out == "" {
+		outs = nil
+	}
+	if len(outs) >= len(ins) {
+		return c
+	}
+	left := make(map[string]int, len(outs))
+	for _, o := range outs {
+		left[o]++
+	}
+	for _, i := range ins {
+		if left[i] > 0 {
+			left[i]--
+			continue
+		}
+		c.Dropped = append(c.Dropped, i)
+	}
+	return c
+}
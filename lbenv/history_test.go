@@ -0,0 +1,114 @@
+package lbenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "lbenv-history-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	outer := filepath.Join(tmpdir, "outer.xml")
+	inner := filepath.Join(tmpdir, "inner.xml")
+
+	for _, table := range []struct {
+		name string
+		cont string
+	}{
+		{
+			name: outer,
+			cont: `<?xml version="1.0" ?>
+<env:config xmlns:env="EnvSchema">
+<env:set variable="MY_PATH">/a</env:set>
+<env:include>inner.xml</env:include>
+<env:set variable="OTHER">1</env:set>
+</env:config>`,
+		},
+		{
+			name: inner,
+			cont: `<?xml version="1.0" ?>
+<env:config xmlns:env="EnvSchema">
+
+<env:append variable="MY_PATH">/b:/b:/c</env:append>
+<env:unset variable="MY_PATH"/>
+</env:config>`,
+		},
+	} {
+		err = ioutil.WriteFile(table.name, []byte(table.cont), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	env := New()
+	env.LoadFromSystem = false
+	err = env.LoadXMLByName(outer)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	steps := env.History("MY_PATH")
+	if len(steps) != 4 {
+		t.Fatalf("expected 4 steps. got=%d\n%v", len(steps), steps)
+	}
+
+	// implicit declaration, then set.
+	for i, step := range steps[:2] {
+		if step.Source == nil || step.Source.File != outer || step.Source.Line != 3 || len(step.Source.Includes) != 0 {
+			t.Errorf("step #%d: unexpected source: %v", i, step.Source)
+		}
+	}
+	if steps[1].Value != "/a" {
+		t.Errorf("step #1: expected %q. got=%q", "/a", steps[1].Value)
+	}
+
+	app := steps[2]
+	if _, ok := app.Action.(*AppendVar); !ok {
+		t.Fatalf("step #2: expected an append. got=%v", app.Action)
+	}
+	if app.Source == nil || app.Source.File != inner || app.Source.Line != 4 ||
+		!reflect.DeepEqual(app.Source.Includes, []string{outer}) {
+		t.Errorf("step #2: unexpected source: %v", app.Source)
+	}
+	if !strings.Contains(app.Source.String(), "included from "+outer) {
+		t.Errorf("step #2: unexpected source: %q", app.Source.String())
+	}
+	if want := "/a:/b:/c"; app.Value != want {
+		t.Errorf("step #2: expected %q. got=%q", want, app.Value)
+	}
+	dropped := false
+	for _, c := range app.Changes {
+		if c.Processor == "lbenv.DuplicatesRemover" {
+			dropped = reflect.DeepEqual(c.Dropped, []string{"/b"})
+		}
+	}
+	if !dropped {
+		t.Errorf("step #2: expected /b to be dropped by lbenv.DuplicatesRemover. got=%+v", app.Changes)
+	}
+
+	if !steps[3].Unset || steps[3].Source.Line != 5 {
+		t.Errorf("step #3: expected an unset at line 5. got=%+v", steps[3])
+	}
+
+	all := env.History("")
+	includes := 0
+	for _, step := range all {
+		if inc, ok := step.Action.(*Include); ok {
+			includes++
+			if inc.File != inner || step.Source == nil || step.Source.Line != 4 {
+				t.Errorf("unexpected include step: %+v", step)
+			}
+		}
+	}
+	if includes != 1 {
+		t.Errorf("expected 1 include step. got=%d", includes)
+	}
+}
@@ -4,271 +4,415 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-func Decode(r io.Reader) ([]Action, error) {
-	var err error
+// DecodeError describes a problem found while decoding an environment XML
+// file.
+type DecodeError struct {
+	File    string // name of the file, if known
+	Line    int    // line of the problem (1-based)
+	Column  int    // column of the problem (1-based, in bytes)
+	Element string // local name of the offending element (e.g. "set"), if any
+	Msg     string // description of the problem
+}
 
-	actions := make([]Action, 0)
-	caller := ""
+func (e *DecodeError) Error() string {
+	file := e.File
+	if file == "" {
+		file = "<input>"
+	}
+	if e.Element != "" {
+		return fmt.Sprintf("%s:%d:%d: <env:%s>: %s", file, e.Line, e.Column, e.Element, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", file, e.Line, e.Column, e.Msg)
+}
+
+// A Decoder reads the actions of an environment XML file.
+//
+// Malformed XML, missing or invalid attribute values and unexpected content
+// are always errors. Unknown elements and attributes are errors in Strict
+// mode, and are otherwise skipped and recorded in Warnings.
+type Decoder struct {
+	Strict   bool           // report unknown elements and attributes as errors
+	Warnings []*DecodeError // problems skipped in lenient mode
+
+	r    io.Reader
+	file string
+}
+
+// NewDecoder returns a lenient decoder reading from r.
+// If r has a Name method (e.g. an *os.File), the name is used in errors and
+// as the caller of the include actions.
+func NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{r: r}
 	if f, ok := r.(interface {
 		Name() string
 	}); ok {
-		caller = f.Name()
+		d.file = f.Name()
 	}
+	return d
+}
+
+// Decode decodes the actions of an environment XML file with a lenient
+// Decoder. Errors are of type *DecodeError.
+func Decode(r io.Reader) ([]Action, error) {
+	return NewDecoder(r).Decode()
+}
+
+// elementSpec describes the attributes and content of an action element.
+type elementSpec struct {
+	attrs []string // known attributes
+	text  bool     // whether the element holds a value
+}
+
+var elementSpecs = map[string]elementSpec{
+	"declare":       {[]string{"variable", "local", "type"}, false},
+	"default":       {[]string{"variable"}, true},
+	"set":           {[]string{"variable"}, true},
+	"unset":         {[]string{"variable"}, false},
+	"append":        {[]string{"variable"}, true},
+	"prepend":       {[]string{"variable"}, true},
+	"remove":        {[]string{"variable"}, true},
+	"remove-regexp": {[]string{"variable"}, true},
+	"include":       {[]string{"hints"}, true},
+}
 
-	dec := xml.NewDecoder(r)
-	var tok xml.Token
+// Decode decodes the actions of the environment XML file.
+// Errors are of type *DecodeError.
+func (d *Decoder) Decode() ([]Action, error) {
+	dec := xml.NewDecoder(d.r)
+	actions := make([]Action, 0)
 	for {
-		tok, err = dec.Token()
+		line, col := dec.InputPos()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return actions, nil
+		}
 		if err != nil {
-			break
+			return nil, d.syntaxError(dec, err)
 		}
-		switch tok := tok.(type) {
-		case xml.StartElement:
-			var action Action
-			switch tok.Name.Local {
-			case "config":
-				continue
-
-			case "declare":
-				var a DeclareVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "local":
-						switch attr.Value {
-						case "true":
-							a.Local = true
-						case "false":
-							a.Local = false
-						}
-
-					case "type":
-						switch attr.Value {
-						case "list":
-							a.Type = VarList
-						case "scalar":
-							a.Type = VarScalar
-						}
-
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-
-			case "default":
-				var a DefaultVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.Value = string(vtok.(xml.CharData))
-
-			case "unset":
-				var a UnsetVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-
-			case "set":
-				var a SetVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.Value = string(vtok.(xml.CharData))
-
-			case "prepend":
-				var a PrependVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.Value = string(vtok.(xml.CharData))
-
-			case "append":
-				var a AppendVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.Value = string(vtok.(xml.CharData))
-
-			case "remove":
-				var a RemoveVar
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.Value = string(vtok.(xml.CharData))
-
-			case "remove-regexp":
-				var a RemoveRegexp
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "variable":
-						a.Name = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.Value = string(vtok.(xml.CharData))
-
-			case "include":
-				var a Include
-				action = &a
-				for _, attr := range tok.Attr {
-					switch attr.Name.Local {
-					case "hints":
-						a.Hints = attr.Value
-					}
-				}
-				var vtok xml.Token
-				vtok, err = dec.Token()
-				if err != nil {
-					return nil, err
-				}
-				a.File = string(vtok.(xml.CharData))
-				a.Caller = caller
-
-			default:
-				fmt.Printf(">>> name=%q\n", tok.Name)
-				for _, attr := range tok.Attr {
-					fmt.Printf("    attr=%q, value=%q\n", attr.Name, attr.Value)
-				}
-				panic(fmt.Errorf("unknown action %q", tok.Name.Local))
-			}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			// text between actions, comments, processing instructions,
+			// directives and end elements.
+			continue
+		}
+		if start.Name.Local == "config" {
+			// the root element only declares namespaces.
+			continue
+		}
+
+		action, err := d.decodeAction(dec, start, line, col)
+		if err != nil {
+			return nil, err
+		}
+		if action != nil {
 			actions = append(actions, action)
+		}
+	}
+}
 
-			var endtok xml.Token
-			endtok, err = dec.Token()
-			if err != nil {
-				return nil, err
-			}
+// decodeAction decodes the action of element start, at line:col, up to and
+// including its end element. Skipped unknown elements yield a nil action.
+func (d *Decoder) decodeAction(dec *xml.Decoder, start xml.StartElement, line, col int) (Action, error) {
+	name := start.Name.Local
+	errorf := func(format string, args ...interface{}) *DecodeError {
+		return &DecodeError{
+			File:    d.file,
+			Line:    line,
+			Column:  col,
+			Element: name,
+			Msg:     fmt.Sprintf(format, args...),
+		}
+	}
 
-			_ = endtok.(xml.EndElement)
+	spec, ok := elementSpecs[name]
+	if !ok {
+		err := d.problem(errorf("unknown element"))
+		if err != nil {
+			return nil, err
+		}
+		err = dec.Skip()
+		if err != nil {
+			return nil, d.syntaxError(dec, err)
+		}
+		return nil, nil
+	}
 
-		case xml.CharData:
-			//fmt.Printf(">>> chardata=%q\n", string(tok))
+	attrs := make(map[string]string, len(start.Attr))
+loop:
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		for _, known := range spec.attrs {
+			if attr.Name.Local == known {
+				attrs[known] = attr.Value
+				continue loop
+			}
+		}
+		err := d.problem(errorf("unknown attribute %q", attr.Name.Local))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		case xml.ProcInst:
-			// noop
+	text, err := decodeText(dec)
+	if err != nil {
+		if _, ok := err.(*xml.SyntaxError); ok || err == io.ErrUnexpectedEOF {
+			return nil, d.syntaxError(dec, err)
+		}
+		return nil, errorf("%v", err)
+	}
+	if !spec.text && strings.TrimSpace(text) != "" {
+		return nil, errorf("unexpected value %q", text)
+	}
 
-		case xml.EndElement:
-			// noop
+	vname, hasName := attrs["variable"]
+	if spec.attrs[0] == "variable" && (!hasName || vname == "") {
+		return nil, errorf("missing \"variable\" attribute")
+	}
 
+	src := &Source{File: d.file, Line: line}
+	switch name {
+	case "declare":
+		a := &DeclareVar{Name: vname, From: src}
+		switch v := attrs["local"]; v {
+		case "true":
+			a.Local = true
+		case "false", "":
+			a.Local = false
+		default:
+			return nil, errorf("invalid \"local\" attribute %q (want true or false)", v)
+		}
+		switch v := attrs["type"]; v {
+		case "list", "":
+			a.Type = VarList
+		case "scalar":
+			a.Type = VarScalar
 		default:
-			fmt.Printf("--- %v (%T)\n", tok, tok)
-			panic(fmt.Errorf("unhandled toke: %[1]v %[1]T", tok))
+			return nil, errorf("invalid \"type\" attribute %q (want list or scalar)", v)
+		}
+		return a, nil
+	case "default":
+		return &DefaultVar{Name: vname, Value: text, From: src}, nil
+	case "set":
+		return &SetVar{Name: vname, Value: text, From: src}, nil
+	case "unset":
+		return &UnsetVar{Name: vname, From: src}, nil
+	case "append":
+		return &AppendVar{Name: vname, Value: text, From: src}, nil
+	case "prepend":
+		return &PrependVar{Name: vname, Value: text, From: src}, nil
+	case "remove":
+		return &RemoveVar{Name: vname, Value: text, From: src}, nil
+	case "remove-regexp":
+		return &RemoveRegexp{Name: vname, Value: text, From: src}, nil
+	case "include":
+		if strings.TrimSpace(text) == "" {
+			return nil, errorf("missing file name")
+		}
+		return &Include{File: text, Caller: d.file, Hints: attrs["hints"], From: src}, nil
+	}
+	return nil, errorf("unknown element")
+}
+
+// problem returns err in strict mode, and records it as a warning otherwise.
+func (d *Decoder) problem(err *DecodeError) error {
+	if d.Strict {
+		return err
+	}
+	d.Warnings = append(d.Warnings, err)
+	return nil
+}
+
+// syntaxError returns the *DecodeError for the error err of dec.
+func (d *Decoder) syntaxError(dec *xml.Decoder, err error) *DecodeError {
+	line, col := dec.InputPos()
+	msg := err.Error()
+	if serr, ok := err.(*xml.SyntaxError); ok {
+		msg = serr.Msg
+		if serr.Line != line {
+			line, col = serr.Line, 0
 		}
 	}
+	if err == io.ErrUnexpectedEOF {
+		msg = "unexpected EOF"
+	}
+	return &DecodeError{File: d.file, Line: line, Column: col, Msg: msg}
+}
 
-	return actions, err
+// decodeText returns the text content of the current element, up to and
+// including its end element.
+func decodeText(dec *xml.Decoder) (string, error) {
+	var text []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", err
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			text = append(text, tok...)
+		case xml.EndElement:
+			return string(text), nil
+		case xml.StartElement:
+			return "", fmt.Errorf("unexpected element <%s> in value", tok.Name.Local)
+		}
+	}
 }
 
+// Encode writes actions to w as an XML environment file.
+// Values are escaped, and values which can not be represented in XML (e.g.
+// holding control characters) are reported as errors.
 func Encode(w io.Writer, actions []Action) error {
 	var err error
-	_, err = fmt.Fprintf(
-		w,
-		`<?xml version="1.0" ?>
-<env:config xmlns:env="EnvSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="EnvSchema ./EnvSchema.xsd ">
-`)
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	root := xml.StartElement{
+		Name: xml.Name{Local: "env:config"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:env"}, Value: "EnvSchema"},
+			{Name: xml.Name{Local: "xmlns:xsi"}, Value: "http://www.w3.org/2001/XMLSchema-instance"},
+			{Name: xml.Name{Local: "xsi:schemaLocation"}, Value: "EnvSchema ./EnvSchema.xsd "},
+		},
+	}
+	err = enc.EncodeToken(root)
 	if err != nil {
 		return err
 	}
 
 	for _, action := range actions {
-		switch v := action.(type) {
-		case *DeclareVar:
-			vtype := ""
-			switch v.Type {
-			case VarScalar:
-				vtype = "scalar"
-			case VarList:
-				vtype = "list"
-			default:
-				panic(fmt.Errorf("unknown variable type %[1]v (%[1]T)", v.Type, v.Type))
-			}
-			_, err = fmt.Fprintf(
-				w, "<env:declare local=\"%v\" type=%q variable=%q/>\n",
-				v.Local, vtype, v.Name,
-			)
-		case *DefaultVar:
-			_, err = fmt.Fprintf(w, "<env:default variable=%q>%s</env:set>\n", v.Name, v.Value)
-		case *SetVar:
-			_, err = fmt.Fprintf(w, "<env:set variable=%q>%s</env:set>\n", v.Name, v.Value)
-		case *UnsetVar:
-			_, err = fmt.Fprintf(w, "<env:unset variable=%q/>\n", v.Name)
-		case *RemoveVar:
-			_, err = fmt.Fprintf(w, "<env:remove variable=%q>%s</env:remove>\n", v.Name, v.Value)
-		case *RemoveRegexp:
-			_, err = fmt.Fprintf(w, "<env:remove-regexp variable=%q>%s</env:remove>\n", v.Name, v.Value)
-		case *AppendVar:
-			_, err = fmt.Fprintf(w, "<env:append variable=%q>%s</env:append>\n",
-				v.Name, v.Value,
-			)
-		case *PrependVar:
-			_, err = fmt.Fprintf(w, "<env:prepend variable=%q>%s</env:prepend>\n",
-				v.Name, v.Value,
-			)
-		case *Include:
-			_, err = fmt.Fprintf(w, "<env:include hints=%q>%s</env:include>\n",
-				v.Hints, v.File,
-			)
-		default:
-			panic(fmt.Errorf("unknown Action type: %[1]v (type=%[1]T)", v))
+		err = encodeAction(enc, action)
+		if err != nil {
+			return err
 		}
 	}
 
-	_, err = fmt.Fprintf(w, "</env:config>\n")
+	err = enc.EncodeToken(root.End())
+	if err != nil {
+		return err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
 	return err
 }
+
+// encodeAction writes action as an env:<op> element.
+func encodeAction(enc *xml.Encoder, action Action) error {
+	var (
+		op    string
+		attrs [][2]string // name, value
+		text  *string
+	)
+
+	switch v := action.(type) {
+	case *DeclareVar:
+		switch v.Type {
+		case VarScalar, VarList:
+		default:
+			return fmt.Errorf("lbenv: unknown variable type %d for %q", int(v.Type), v.Name)
+		}
+		op = "declare"
+		attrs = [][2]string{
+			{"local", strconv.FormatBool(v.Local)},
+			{"type", v.Type.String()},
+			{"variable", v.Name},
+		}
+	case *DefaultVar:
+		op, attrs, text = "default", [][2]string{{"variable", v.Name}}, &v.Value
+	case *SetVar:
+		op, attrs, text = "set", [][2]string{{"variable", v.Name}}, &v.Value
+	case *UnsetVar:
+		op, attrs = "unset", [][2]string{{"variable", v.Name}}
+	case *RemoveVar:
+		op, attrs, text = "remove", [][2]string{{"variable", v.Name}}, &v.Value
+	case *RemoveRegexp:
+		op, attrs, text = "remove-regexp", [][2]string{{"variable", v.Name}}, &v.Value
+	case *AppendVar:
+		op, attrs, text = "append", [][2]string{{"variable", v.Name}}, &v.Value
+	case *PrependVar:
+		op, attrs, text = "prepend", [][2]string{{"variable", v.Name}}, &v.Value
+	case *Include:
+		op, text = "include", &v.File
+		if v.Hints != "" {
+			attrs = [][2]string{{"hints", v.Hints}}
+		}
+	default:
+		return fmt.Errorf("lbenv: unknown Action type: %[1]v (type=%[1]T)", v)
+	}
+
+	for _, attr := range attrs {
+		if attr[0] == "variable" && attr[1] == "" {
+			return fmt.Errorf("lbenv: env:%s with an empty variable name", op)
+		}
+	}
+	if op == "include" && strings.TrimSpace(*text) == "" {
+		return fmt.Errorf("lbenv: env:include with an empty file name")
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: "env:" + op}}
+	for _, attr := range attrs {
+		err := checkXMLText(attr[1])
+		if err != nil {
+			return fmt.Errorf("lbenv: invalid %s attribute of env:%s: %v", attr[0], op, err)
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attr[0]}, Value: attr[1]})
+	}
+
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	if text != nil && *text != "" {
+		err = checkXMLText(*text)
+		if err != nil {
+			return fmt.Errorf("lbenv: invalid value of env:%s: %v", op, err)
+		}
+		err = enc.EncodeToken(xml.CharData(*text))
+		if err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// checkXMLText returns an error if s holds characters which can not be
+// represented in an XML document.
+func checkXMLText(s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("invalid UTF-8 string %q", s)
+	}
+	for _, r := range s {
+		if !isXMLChar(r) {
+			return fmt.Errorf("invalid XML character %U in %q", r, s)
+		}
+	}
+	return nil
+}
+
+// isXMLChar returns whether r is in the Char production of the XML spec.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
@@ -0,0 +1,181 @@
+package lbenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ParseShellType returns the ShellType corresponding to a shell name or path
+// (e.g. "bash", "/bin/tcsh", "fish" or "json")
+func ParseShellType(name string) (ShellType, error) {
+	switch filepath.Base(name) {
+	case "sh", "bash", "dash", "zsh", "ksh":
+		return ShType, nil
+	case "csh", "tcsh":
+		return CshType, nil
+	case "bat", "cmd", "cmd.exe":
+		return BatType, nil
+	case "fish":
+		return FishType, nil
+	case "json":
+		return JSONType, nil
+	}
+	return ShType, fmt.Errorf("lbenv: unknown shell type %q", name)
+}
+
+func (sh ShellType) String() string {
+	switch sh {
+	case ShType:
+		return "sh"
+	case CshType:
+		return "csh"
+	case BatType:
+		return "bat"
+	case FishType:
+		return "fish"
+	case JSONType:
+		return "json"
+	}
+	return fmt.Sprintf("ShellType(%d)", int(sh))
+}
+
+// Quote quotes a value so it is read back verbatim by the given shell.
+func Quote(shell ShellType, value string) string {
+	switch shell {
+	case CshType:
+		return cshQuote(value)
+	case FishType:
+		return fishQuote(value)
+	case BatType:
+		return batQuote(value)
+	}
+	return shQuote(value)
+}
+
+// shQuote quotes a value for sh-like shells.
+func shQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// cshQuote quotes a value for csh-like shells.
+// history substitutions and newlines need to be escaped even in single quotes.
+func cshQuote(value string) string {
+	value = strings.Replace(value, "'", `'\''`, -1)
+	value = strings.Replace(value, "!", `\!`, -1)
+	value = strings.Replace(value, "\n", "\\\n", -1)
+	return "'" + value + "'"
+}
+
+// fishQuote quotes a value for the fish shell.
+func fishQuote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "'", `\'`, -1)
+	return "'" + value + "'"
+}
+
+// batQuote quotes a value for a windows BAT script.
+func batQuote(value string) string {
+	value = strings.Replace(value, "%", "%%", -1)
+	value = strings.Replace(value, "\n", " ", -1)
+	return value
+}
+
+// reShellName matches the variable names a shell can set or unset.
+var reShellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeScript writes the script setting the variables of set (key=value) and
+// unsetting the variables of unset, for the given shell type.
+// Variables whose name is not a valid shell identifier cannot be exported by
+// a shell script: they are skipped and their names returned.
+func writeScript(shell ShellType, w io.Writer, set map[string]string, unset []string) ([]string, error) {
+	var err error
+
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	unset = append([]string(nil), unset...)
+	sort.Strings(unset)
+
+	if shell == JSONType {
+		doc := make(map[string]*string, len(set)+len(unset))
+		for _, k := range unset {
+			doc[k] = nil
+		}
+		for _, k := range keys {
+			v := set[k]
+			doc[k] = &v
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return nil, enc.Encode(doc)
+	}
+
+	var skipped []string
+	keys, skipped = filterShellNames(keys, skipped)
+	unset, skipped = filterShellNames(unset, skipped)
+	sort.Strings(skipped)
+
+	var header, footer, setfmt, unsetfmt string
+	var quote func(string) string
+	switch shell {
+	case ShType:
+		header, footer = "#!/bin/sh\n", "## EOF\n"
+		setfmt, unsetfmt = "export %s=%s\n", "unset %s\n"
+		quote = shQuote
+	case CshType:
+		header, footer = "#!/bin/csh\n", "## EOF\n"
+		setfmt, unsetfmt = "setenv %s %s\n", "unsetenv %s\n"
+		quote = cshQuote
+	case FishType:
+		header, footer = "#!/usr/bin/env fish\n", "## EOF\n"
+		setfmt, unsetfmt = "set -gx %s %s\n", "set -e %s\n"
+		quote = fishQuote
+	case BatType:
+		header, footer = "@echo off\n", "REM EOF\n"
+		setfmt, unsetfmt = "set %s=%s\n", "set %s=\n"
+		quote = batQuote
+	default:
+		return nil, fmt.Errorf("lbenv: unhandled shell type %v", shell)
+	}
+
+	_, err = io.WriteString(w, header)
+	if err != nil {
+		return skipped, err
+	}
+	for _, k := range unset {
+		_, err = fmt.Fprintf(w, unsetfmt, k)
+		if err != nil {
+			return skipped, err
+		}
+	}
+	for _, k := range keys {
+		_, err = fmt.Fprintf(w, setfmt, k, quote(set[k]))
+		if err != nil {
+			return skipped, err
+		}
+	}
+	_, err = io.WriteString(w, footer)
+	return skipped, err
+}
+
+// filterShellNames returns the valid shell variable names of names, and
+// appends the other ones to skipped.
+func filterShellNames(names, skipped []string) ([]string, []string) {
+	valid := names[:0]
+	for _, name := range names {
+		if !reShellName.MatchString(name) {
+			skipped = append(skipped, name)
+			continue
+		}
+		valid = append(valid, name)
+	}
+	return valid, skipped
+}
@@ -0,0 +1,181 @@
+package lbenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+var g_tricky_values = []string{
+	"simple",
+	"with space",
+	`with "double" quotes`,
+	"with 'single' quotes",
+	`back\slash`,
+	"$HOME and `cmd` and $(cmd)",
+	"bang! and &<>|;",
+	"new\nline",
+	"",
+}
+
+func TestGenScriptQuoting(t *testing.T) {
+	for _, table := range []struct {
+		shell ShellType
+		bin   string
+		args  []string
+	}{
+		{ShType, "sh", []string{"-c", `. "$0" && printf %s "$LBX_VAR"`}},
+		{CshType, "tcsh", []string{"-f", "-c", `source $0 && printf %s "$LBX_VAR"`}},
+		{FishType, "fish", []string{"-c", `source $argv[1]; and printf %s "$LBX_VAR"`}},
+	} {
+		bin, err := exec.LookPath(table.bin)
+		if err != nil {
+			t.Logf("%s not available. skipping %v", table.bin, table.shell)
+			continue
+		}
+
+		for _, value := range g_tricky_values {
+			env := New()
+			err := env.Set("LBX_VAR", value)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			var buf bytes.Buffer
+			err = env.GenScriptDiff(table.shell, &buf, nil)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+
+			cmd := exec.Command(bin, append(table.args, "/dev/stdin")...)
+			cmd.Stdin = &buf
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v: error running script for %q: %v", table.shell, value, err)
+			}
+			if string(out) != value {
+				t.Fatalf("%v: expected %q. got=%q", table.shell, value, string(out))
+			}
+		}
+	}
+}
+
+func TestGenScriptDiff(t *testing.T) {
+	env := New()
+	env.LoadFromSystem = false
+	for _, kv := range [][2]string{
+		{"SAME", "same"},
+		{"CHANGED", "new"},
+		{"ADDED", "added"},
+	} {
+		err := env.Set(kv[0], kv[1])
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	environ := []string{"SAME=same", "CHANGED=old", "REMOVED=value"}
+
+	var buf bytes.Buffer
+	err := env.GenScriptDiff(ShType, &buf, environ)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	exp := `#!/bin/sh
+unset REMOVED
+export ADDED='added'
+export CHANGED='new'
+## EOF
+`
+	if buf.String() != exp {
+		t.Fatalf("error:\nexp=%v\ngot=%v\n", exp, buf.String())
+	}
+
+	buf.Reset()
+	err = env.GenScriptDiff(JSONType, &buf, environ)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	var doc map[string]*string
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	added, changed := "added", "new"
+	want := map[string]*string{
+		"ADDED":   &added,
+		"CHANGED": &changed,
+		"REMOVED": nil,
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("expected %v. got=%v", want, doc)
+	}
+}
+
+func TestGenScriptSkipInvalidNames(t *testing.T) {
+	env := New()
+	env.LoadFromSystem = false
+	for _, name := range []string{"GOOD_1", "_ok", "1BAD", "BAD-NAME", "BAD.NAME"} {
+		err := env.Set(name, "value")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	environ := []string{"BASH_FUNC_f%%=() { :; }", "GONE=value"}
+
+	var buf bytes.Buffer
+	err := env.GenScriptDiff(ShType, &buf, environ)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	exp := `#!/bin/sh
+unset GONE
+export GOOD_1='value'
+export _ok='value'
+## EOF
+`
+	if buf.String() != exp {
+		t.Fatalf("error:\nexp=%v\ngot=%v\n", exp, buf.String())
+	}
+	want := []string{"1BAD", "BAD-NAME", "BAD.NAME", "BASH_FUNC_f%%"}
+	if !reflect.DeepEqual(env.Skipped, want) {
+		t.Fatalf("expected skipped %v. got=%v", want, env.Skipped)
+	}
+
+	// JSON keys can be any string.
+	buf.Reset()
+	err = env.GenScriptDiff(JSONType, &buf, environ)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(env.Skipped) != 0 {
+		t.Fatalf("expected no skipped variable. got=%v", env.Skipped)
+	}
+}
+
+func TestParseShellType(t *testing.T) {
+	for _, table := range []struct {
+		name string
+		want ShellType
+	}{
+		{"sh", ShType},
+		{"/bin/bash", ShType},
+		{"/usr/bin/zsh", ShType},
+		{"tcsh", CshType},
+		{"/bin/csh", CshType},
+		{"fish", FishType},
+		{"json", JSONType},
+	} {
+		sh, err := ParseShellType(table.name)
+		if err != nil {
+			t.Fatalf("%q: error: %v", table.name, err)
+		}
+		if sh != table.want {
+			t.Fatalf("%q: expected %v. got=%v", table.name, table.want, sh)
+		}
+	}
+
+	if _, err := ParseShellType("python"); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,94 @@
+package lbenv
+
+import (
+	"strings"
+)
+
+// Inverse returns the actions reverting the changes made by the environment
+// to the variables of environ, the environment it started from (as key=value
+// pairs, like os.Environ).
+//
+// The inverse actions restore the overwritten values, remove the entries
+// appended or prepended to lists, re-set the unset variables and unset the
+// new ones. They are meant to be replayed, without processors, on the
+// environment resulting from this one.
+func (env *Environment) Inverse(environ []string) []Action {
+	start := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		start[kv[:i]] = kv[i+1:]
+	}
+
+	inv := make([]Action, 0)
+	seen := make(map[string]struct{})
+	// revert the last modified variables first.
+	for i := len(env.history) - 1; i >= 0; i-- {
+		name := env.history[i].Var
+		if name == "" || name == "." {
+			continue
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		old, wasSet := start[name]
+		v, isSet := env.vars[name]
+		// local variables are not exported to the shell.
+		isSet = isSet && !v.Local
+
+		switch {
+		case !wasSet && !isSet:
+			// nothing to revert.
+		case !wasSet:
+			inv = append(inv, &UnsetVar{Name: name})
+		case !isSet:
+			inv = append(inv, &SetVar{Name: name, Value: old})
+		case v.Value != old:
+			inv = append(inv, inverseValue(v, old)...)
+		}
+	}
+	return inv
+}
+
+// inverseValue returns the actions restoring the old value of v.
+// When v is the old list with some entries added around it, the added
+// entries are removed so later modifications of the variable are kept.
+// Otherwise, the old value is set back.
+func inverseValue(v Var, old string) []Action {
+	reset := []Action{&SetVar{Name: v.Name, Value: old}}
+	if v.Type != VarList || old == "" {
+		return reset
+	}
+
+	olds := splitpath(old)
+	news := splitpath(v.Value)
+	for i := 0; i+len(olds) <= len(news); i++ {
+		if !equal_str_slices(news[i:i+len(olds)], olds) {
+			continue
+		}
+		added := make([]string, 0, len(news)-len(olds))
+		added = append(added, news[:i]...)
+		added = append(added, news[i+len(olds):]...)
+
+		// removing an entry removes all its occurrences: only do it for
+		// entries which were not already there.
+		acts := []Action{&DeclareVar{Name: v.Name, Type: VarList}}
+		removed := make(map[string]struct{}, len(added))
+		for _, entry := range added {
+			if entry == "" || in_str_slice(entry, olds) {
+				return reset
+			}
+			if _, dup := removed[entry]; dup {
+				continue
+			}
+			removed[entry] = struct{}{}
+			acts = append(acts, &RemoveVar{Name: v.Name, Value: entry})
+		}
+		return acts
+	}
+	return reset
+}
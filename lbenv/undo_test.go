@@ -0,0 +1,79 @@
+package lbenv
+
+import (
+	"testing"
+)
+
+func TestInverse(t *testing.T) {
+	start := []string{
+		"MY_PATH=/sys/a:/sys/b",
+		"MY_DIRS=/sys/a:/sys/b",
+		"MY_SCALAR=old",
+		"OLD_VAR=gone",
+		"MY_LOCAL=shadowed",
+		"UNTOUCHED=same",
+	}
+
+	env := New()
+	env.System = start
+	for _, act := range []Action{
+		&PrependVar{Name: "MY_PATH", Value: "/proj/bin"},
+		&AppendVar{Name: "MY_PATH", Value: "/proj/lib"},
+		&AppendVar{Name: "MY_DIRS", Value: "/sys/a"},
+		&SetVar{Name: "MY_SCALAR", Value: "new"},
+		&SetVar{Name: "NEW_VAR", Value: "x"},
+		&UnsetVar{Name: "OLD_VAR"},
+		&DeclareVar{Name: "MY_LOCAL", Type: VarScalar, Local: true},
+		&SetVar{Name: "MY_LOCAL", Value: "local"},
+		&DeclareVar{Name: "UNTOUCHED", Type: VarScalar},
+	} {
+		err := act.Run(env)
+		if err != nil {
+			t.Fatalf("error running %v: %v", act, err)
+		}
+	}
+
+	// the shell after evaluating the environment, and a later modification.
+	current := []string{
+		"MY_PATH=/user/bin:/proj/bin:/sys/a:/sys/b:/proj/lib",
+		"MY_DIRS=/sys/a:/sys/b:/sys/a",
+		"MY_SCALAR=new",
+		"NEW_VAR=x",
+		"UNTOUCHED=same",
+	}
+
+	undo := New()
+	undo.Processors = nil
+	undo.System = current
+	err := undo.Replay(env.Inverse(start))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for _, table := range []struct {
+		name  string
+		value string
+		unset bool
+	}{
+		{name: "MY_PATH", value: "/user/bin:/sys/a:/sys/b"},
+		{name: "MY_DIRS", value: "/sys/a:/sys/b"},
+		{name: "MY_SCALAR", value: "old"},
+		{name: "NEW_VAR", unset: true},
+		{name: "OLD_VAR", value: "gone"},
+		{name: "MY_LOCAL", value: "shadowed"},
+	} {
+		if table.unset {
+			if undo.Has(table.name) {
+				t.Errorf("expected %s to be unset. got=%q", table.name, undo.Get(table.name).Value)
+			}
+			continue
+		}
+		if v := undo.Get(table.name).Value; v != table.value {
+			t.Errorf("expected %s=%q. got=%q", table.name, table.value, v)
+		}
+	}
+
+	if undo.Has("UNTOUCHED") {
+		t.Errorf("expected UNTOUCHED not to be reverted")
+	}
+}
@@ -39,3 +39,15 @@ func str_actions(actions []Action) []string {
 	}
 	return o
 }
+
+func equal_str_slices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -3,8 +3,11 @@ package lbenv
 import (
 	"bytes"
 	"io"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
 )
 
 func TestXMLOps(t *testing.T) {
@@ -94,9 +97,253 @@ func TestXMLOps(t *testing.T) {
 		t.Fatalf("expected %d actions. got=%d", len(expected), len(actions))
 	}
 
+	for i, action := range actions {
+		src := action.(Sourced).Source()
+		if src == nil || src.Line != i+3 {
+			t.Errorf("actions[%d]: expected a source at line %d. got=%v", i, i+3, src)
+		}
+	}
+
+	actions = withoutSources(actions)
 	for i := 0; i < len(expected); i++ {
 		if !reflect.DeepEqual(actions[i], expected[i]) {
-			t.Fatalf("actions[%d]=%v\nexpected=%v", actions[i], expected[i])
+			t.Fatalf("actions[%d]=%v\nexpected=%v", i, actions[i], expected[i])
+		}
+	}
+}
+
+// withoutSources returns copies of actions without their provenance.
+func withoutSources(actions []Action) []Action {
+	o := make([]Action, len(actions))
+	for i, action := range actions {
+		v := reflect.New(reflect.TypeOf(action).Elem())
+		v.Elem().Set(reflect.ValueOf(action).Elem())
+		from := v.Elem().FieldByName("From")
+		from.Set(reflect.Zero(from.Type()))
+		o[i] = v.Interface().(Action)
+	}
+	return o
+}
+
+// xmlRunes are the runes of the generated values: XML markup, whitespace,
+// and multi-byte characters.
+var xmlRunes = []rune("aZ09 _-.:/$&<>\"'\t\n\r]]>;#=é€\U0001F600\u00a0")
+
+func randXMLString(r *rand.Rand, size int) string {
+	n := r.Intn(size + 1)
+	rs := make([]rune, n)
+	for i := range rs {
+		rs[i] = xmlRunes[r.Intn(len(xmlRunes))]
+	}
+	return string(rs)
+}
+
+// actionList is a list of actions, generated by testing/quick.
+type actionList []Action
+
+func (actionList) Generate(r *rand.Rand, size int) reflect.Value {
+	actions := make(actionList, r.Intn(size+1))
+	for i := range actions {
+		// variable and file names can not be empty.
+		name := "v" + randXMLString(r, size)
+		value := randXMLString(r, size)
+		switch r.Intn(9) {
+		case 0:
+			actions[i] = &DeclareVar{Name: name, Local: r.Intn(2) == 0, Type: VarType(r.Intn(2))}
+		case 1:
+			actions[i] = &SetVar{Name: name, Value: value}
+		case 2:
+			actions[i] = &DefaultVar{Name: name, Value: value}
+		case 3:
+			actions[i] = &UnsetVar{Name: name}
+		case 4:
+			actions[i] = &AppendVar{Name: name, Value: value}
+		case 5:
+			actions[i] = &PrependVar{Name: name, Value: value}
+		case 6:
+			actions[i] = &RemoveVar{Name: name, Value: value}
+		case 7:
+			actions[i] = &RemoveRegexp{Name: name, Value: value}
+		case 8:
+			actions[i] = &Include{File: "f" + value, Hints: randXMLString(r, size)}
+		}
+	}
+	return reflect.ValueOf(actions)
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	roundtrip := func(actions actionList) bool {
+		var buf bytes.Buffer
+		err := Encode(&buf, actions)
+		if err != nil {
+			t.Errorf("error encoding: %v", err)
+			return false
+		}
+		got, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil && err != io.EOF {
+			t.Errorf("error decoding: %v\n%s", err, buf.String())
+			return false
+		}
+		got = withoutSources(got)
+		if len(got) != len(actions) {
+			t.Errorf("expected %d actions. got=%d\n%s", len(actions), len(got), buf.String())
+			return false
+		}
+		for i := range actions {
+			if !reflect.DeepEqual(got[i], actions[i]) {
+				t.Errorf("actions[%d]=%#v\nexpected=%#v\n%s", i, got[i], actions[i], buf.String())
+				return false
+			}
+		}
+		return true
+	}
+
+	err := quick.Check(roundtrip, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXMLEncodeInvalid(t *testing.T) {
+	for _, action := range []Action{
+		&SetVar{Name: "A", Value: "bell\a"},
+		&SetVar{Name: "nul\x00", Value: "value"},
+		&AppendVar{Name: "A", Value: "\xff\xfe"},
+		&DeclareVar{Name: "A", Type: VarType(42)},
+		&UnsetVar{Name: ""},
+		&Include{File: " "},
+	} {
+		var buf bytes.Buffer
+		err := Encode(&buf, []Action{action})
+		if err == nil {
+			t.Errorf("expected an error encoding %#v.\n%s", action, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Encode(&buf, []Action{
+		&DefaultVar{Name: "A", Value: "a&b"},
+		&RemoveRegexp{Name: "B", Value: "^<.*>$"},
+	})
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	for _, want := range []string{
+		`<env:default variable="A">a&amp;b</env:default>`,
+		`<env:remove-regexp variable="B">^&lt;.*&gt;$</env:remove-regexp>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestXMLDecodeEmpty(t *testing.T) {
+	const data = `<env:config xmlns:env="EnvSchema">
+<env:set variable="X"/>
+<env:append variable="Y"></env:append>
+</env:config>
+`
+	actions, err := Decode(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	actions = withoutSources(actions)
+	expected := []Action{
+		&SetVar{Name: "X"},
+		&AppendVar{Name: "Y"},
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("expected=%v\ngot=%v", expected, actions)
+	}
+}
+
+func TestXMLDecodeUnknown(t *testing.T) {
+	const data = `<env:config xmlns:env="EnvSchema">
+<env:set variable="X">1</env:set>
+  <env:frobnicate variable="X"><env:set variable="Z">3</env:set></env:frobnicate>
+<env:set variable="Y" colour="blue">2</env:set>
+</env:config>
+`
+	dec := NewDecoder(strings.NewReader(data))
+	actions, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("lenient: error: %v", err)
+	}
+	actions = withoutSources(actions)
+	expected := []Action{
+		&SetVar{Name: "X", Value: "1"},
+		&SetVar{Name: "Y", Value: "2"},
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("lenient: expected=%v\ngot=%v", expected, actions)
+	}
+	if len(dec.Warnings) != 2 {
+		t.Fatalf("lenient: expected 2 warnings. got=%v", dec.Warnings)
+	}
+	if w := dec.Warnings[0]; w.Element != "frobnicate" || w.Line != 3 || w.Column != 3 {
+		t.Errorf("lenient: unexpected warning: %+v", w)
+	}
+	if w := dec.Warnings[1]; w.Element != "set" || w.Line != 4 || !strings.Contains(w.Msg, "colour") {
+		t.Errorf("lenient: unexpected warning: %+v", w)
+	}
+
+	dec = NewDecoder(strings.NewReader(data))
+	dec.Strict = true
+	_, err = dec.Decode()
+	derr, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("strict: expected a *DecodeError. got=%T (%v)", err, err)
+	}
+	if derr.Element != "frobnicate" || derr.Line != 3 || derr.Column != 3 {
+		t.Errorf("strict: unexpected error: %+v", derr)
+	}
+}
+
+func TestXMLDecodeError(t *testing.T) {
+	for _, table := range []struct {
+		data string
+		line int
+		elem string
+		msg  string
+	}{
+		{
+			data: "<env:config>\n<env:set>1</env:set>\n</env:config>",
+			line: 2, elem: "set", msg: `missing "variable" attribute`,
+		},
+		{
+			data: "<env:config>\n<env:declare variable=\"X\" type=\"map\"/>\n</env:config>",
+			line: 2, elem: "declare", msg: `invalid "type" attribute`,
+		},
+		{
+			data: "<env:config>\n<env:unset variable=\"X\">1</env:unset>\n</env:config>",
+			line: 2, elem: "unset", msg: "unexpected value",
+		},
+		{
+			data: "<env:config>\n\n<env:include>  </env:include>\n</env:config>",
+			line: 3, elem: "include", msg: "missing file name",
+		},
+		{
+			data: "<env:config>\n<env:set variable=\"X\"><b/></env:set>\n</env:config>",
+			line: 2, elem: "set", msg: "unexpected element <b>",
+		},
+		{
+			data: "<env:config>\n<env:set variable=\"X\">1</env:append>\n</env:config>",
+			line: 2, msg: "element <set> closed by </append>",
+		},
+		{
+			data: "<env:config>\n<env:set variable=\"X\">1",
+			line: 2, msg: "unexpected EOF",
+		},
+	} {
+		_, err := Decode(strings.NewReader(table.data))
+		derr, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("%q: expected a *DecodeError. got=%T (%v)", table.data, err, err)
+			continue
+		}
+		if derr.Line != table.line || derr.Element != table.elem || !strings.Contains(derr.Msg, table.msg) {
+			t.Errorf("%q: expected line=%d elem=%q msg=%q. got=%+v", table.data, table.line, table.elem, table.msg, derr)
 		}
 	}
 }
@@ -1,19 +1,45 @@
 package lbrelease
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/gonuts/toml"
 	"github.com/lhcb-org/lbx/lbctx"
 	"github.com/lhcb-org/lbx/lbctx/vcs"
 )
 
+// pkgsdb is the name of the cache of the packages known to the repositories
+const pkgsdb = "packages-db.toml"
+
+// pkgsdbPath returns the path to the packages cache: in the .lbx directory
+// of the current workarea, or in ~/.lbx outside of a workarea.
+// An empty path disables the cache.
+func pkgsdbPath() string {
+	if fi, err := os.Stat(".lbx"); err == nil && fi.IsDir() {
+		return filepath.Join(".lbx", pkgsdb)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".lbx", pkgsdb)
+	}
+	return ""
+}
+
 type GetPack struct {
-	Verbose    bool
+	// Deprecated: Verbose is ignored. The output of a failed version control
+	// command is in the *vcs.Error returned by Checkout.
+	Verbose bool
+
 	ReqPkg     string // requested package
 	ReqPkgVers string
+	Refresh    bool   // query the repositories even if a packages cache exists
+	User       string // user name for the repositories URLs
+	Protocol   string // only use the repositories URLs with this protocol (e.g. ssh, http)
 
 	pkgs  lbctx.Packages
 	projs []string
@@ -25,32 +51,43 @@ type GetPack struct {
 	proj_name string
 	proj_vers string
 
+	mu   sync.Mutex // protects the initialization of repos and pkgs
 	init bool
 }
 
-func (gp *GetPack) setup() error {
+func (gp *GetPack) setup(ctx context.Context) error {
 	var err error
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
 	if gp.init {
 		return err
 	}
 
-	err = gp.initRepos(nil, "", "")
+	err = gp.initRepos(nil, gp.User, gp.Protocol)
 	if err != nil {
 		return err
 	}
 
-	const fname = ".lbx/packages-db.toml"
-	if _, err := os.Stat(fname); err == nil {
-		return gp.loadPkgs(fname)
+	fname := pkgsdbPath()
+	if fname != "" && !gp.Refresh {
+		ok, err := gp.loadPkgs(fname)
+		if err != nil || ok {
+			return err
+		}
 	}
 
-	err = gp.initPkgs()
+	// a partial list of packages is not cached: the next run would not
+	// find the packages of the missing repositories.
+	err = gp.initPkgs(ctx)
 	if err != nil {
 		return err
 	}
 
 	gp.init = true
 
+	if fname == "" {
+		return err
+	}
 	return gp.savePkgs(fname)
 }
 
@@ -65,11 +102,16 @@ func (gp *GetPack) initRepos(excludes []string, user, protocol string) error {
 		excl[v] = struct{}{}
 	}
 
-	gp.repos = make(lbctx.RepoDb, 3)
+	repos, err := lbctx.LoadRepositories(user, protocol)
+	if err != nil {
+		return err
+	}
+
+	gp.repos = make(lbctx.RepoDb, len(repos))
 
 	// prepare repositories urls
 	// filter the requested protocols for the known repositories
-	for k, v := range lbctx.Repositories(user, protocol) {
+	for k, v := range repos {
 		if _, dup := excl[k]; dup {
 			continue
 		}
@@ -83,96 +125,230 @@ func (gp *GetPack) initRepos(excludes []string, user, protocol string) error {
 	return err
 }
 
-func (gp *GetPack) initPkgs() error {
+func (gp *GetPack) initPkgs(ctx context.Context) error {
 	var err error
 	if gp.pkgs != nil {
 		return err
 	}
 
-	gp.pkgs = make(lbctx.Packages)
-
-	pkgs := make(chan []lbctx.Package, len(gp.repos))
+	results := make(chan repoPkgs, len(gp.repos))
 	for repo := range gp.repos {
 		go func(n string) {
 			repo := gp.repos[n]
-			pkgs <- repo.ListPackages(gp.sel_hat)
+			pkgs, err := repo.ListPackagesContext(ctx, gp.sel_hat)
+			results <- repoPkgs{
+				name: n,
+				prio: repo[0].Priority,
+				pkgs: pkgs,
+				err:  err,
+			}
 		}(repo)
 	}
 
+	all := make([]repoPkgs, 0, len(gp.repos))
 	for _ = range gp.repos {
-		ps := <-pkgs
-		for _, pkg := range ps {
-			gp.pkgs[pkg.Name] = pkg
+		res := <-results
+		if res.err != nil && err == nil {
+			err = fmt.Errorf("getpack: could not list the packages of repository [%s]: %v", res.name, res.err)
 		}
+		all = append(all, res)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	if err != nil {
+		return err
+	}
+
+	// packages from higher priority repositories win.
+	sort.Sort(reposByPriority(all))
+	pkgs := make(lbctx.Packages)
+	for _, res := range all {
+		for _, pkg := range res.pkgs {
+			pkgs[pkg.Name] = pkg
+		}
+	}
+	gp.pkgs = pkgs
 	return err
 }
 
+// repoPkgs holds the packages listed by a repository.
+type repoPkgs struct {
+	name string
+	prio int
+	pkgs []lbctx.Package
+	err  error // error listing the packages
+}
+
+type reposByPriority []repoPkgs
+
+func (p reposByPriority) Len() int { return len(p) }
+func (p reposByPriority) Less(i, j int) bool {
+	if p[i].prio != p[j].prio {
+		return p[i].prio < p[j].prio
+	}
+	return p[i].name < p[j].name
+}
+func (p reposByPriority) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// Packages returns the list of packages known to the repositories whose
+// name starts with hat, sorted by name.
+func (gp *GetPack) Packages(hat string) ([]lbctx.Package, error) {
+	err := gp.setup(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := make([]lbctx.Package, 0, len(gp.pkgs))
+	for _, pkg := range gp.pkgs {
+		if !strings.HasPrefix(pkg.Name, hat) {
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Sort(pkgsByName(pkgs))
+	return pkgs, nil
+}
+
+type pkgsByName []lbctx.Package
+
+func (p pkgsByName) Len() int           { return len(p) }
+func (p pkgsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p pkgsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 func (gp *GetPack) Run() error {
+	return gp.Checkout(gp.ReqPkg, gp.ReqPkgVers)
+}
+
+// Checkout checks out the package name at the given version (trunk if empty,
+// "head" or "trunk") in the current directory.
+// Checkout is safe for concurrent use.
+func (gp *GetPack) Checkout(name, version string) error {
+	return gp.CheckoutContext(context.Background(), name, version)
+}
+
+// CheckoutContext is like Checkout but the version control commands are
+// killed if ctx is done before they complete.
+func (gp *GetPack) CheckoutContext(ctx context.Context, name, version string) error {
 	var err error
-	err = gp.setup()
+	err = gp.setup(ctx)
 	if err != nil {
 		return err
 	}
 
-	pkg, ok := gp.pkgs[gp.ReqPkg]
+	pkg, ok := gp.pkgs[name]
 	if !ok {
-		return fmt.Errorf("lbrelease: no such package [%s]", gp.ReqPkg)
+		return fmt.Errorf("lbrelease: no such package [%s]", name)
+	}
+
+	var repo *lbctx.RepoInfo
+	for _, r := range gp.repos {
+		for i := range r {
+			if r[i].Repo == pkg.Repo {
+				repo = &r[i]
+				break
+			}
+		}
+	}
+	if repo == nil {
+		return fmt.Errorf("lbrelease: no repository [%s] for package [%s]", pkg.Repo, pkg.Name)
+	}
+
+	if repo.VCS == "git" {
+		return gitCheckout(ctx, pkg, version)
 	}
 
 	var url []string
-	switch gp.ReqPkgVers {
+	switch version {
 	case "", "head", "trunk":
 		url = []string{pkg.Repo, pkg.Project, "trunk", pkg.Name}
 	default:
-		url = []string{pkg.Repo, pkg.Project, "tags", pkg.Name, gp.ReqPkgVers}
+		url = []string{pkg.Repo, pkg.Project, "tags", pkg.Name, version}
 	}
 
-	var repo *lbctx.RepoInfo
-	for _, r := range gp.repos {
-		if r[0].Repo == pkg.Repo {
-			repo = &r[0]
-			break
-		}
-	}
+	_, err = vcs.RunContext(ctx, repo.Cmd, "checkout {url} ./{dir}", "url", strings.Join(url, "/"), "dir", pkg.Name)
+	return err
+}
 
-	cmd := vcs.Command(repo.Cmd, "checkout {url} ./{dir}", "url", strings.Join(url, "/"), "dir", pkg.Name)
-	if gp.Verbose {
-		cmd.Stdout = os.Stdout
+// gitCheckout adds pkg to the sparse checkout of the repository of its
+// project, under ./<project>.
+// Checkouts from the same repository are serialized by vcs.Helper.
+func gitCheckout(ctx context.Context, pkg lbctx.Package, tag string) error {
+	switch tag {
+	case "", "head", "trunk":
+		tag = "master"
 	}
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		return err
+	h := &vcs.Helper{
+		Type:    "git",
+		Repo:    pkg.Repo,
+		RepoDir: pkg.Project,
+		PkgName: pkg.Name,
+		PkgId:   tag,
+		PkgDir:  ".",
 	}
+	return h.CheckoutContext(ctx)
+}
 
-	return err
+// pkgsCache is the content of the packages cache.
+// The URLs of the repositories depend on the user name and protocol: a cache
+// written for other ones is not used.
+type pkgsCache struct {
+	User     string
+	Protocol string
+	Packages lbctx.Packages
 }
 
-func (gp *GetPack) loadPkgs(fname string) error {
-	ctx := struct {
-		Packages lbctx.Packages
-	}{}
-	_, err := toml.DecodeFile(fname, &ctx)
+// loadPkgs loads the packages from the cache fname, and returns whether it
+// could be used: a missing cache, one for another user or protocol, or one
+// with packages of unknown repositories (e.g. removed from the repositories
+// database) is not.
+func (gp *GetPack) loadPkgs(fname string) (bool, error) {
+	if _, err := os.Stat(fname); err != nil {
+		return false, nil
+	}
+	var cache pkgsCache
+	_, err := toml.DecodeFile(fname, &cache)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if cache.User != gp.User || cache.Protocol != gp.Protocol {
+		return false, nil
+	}
+	urls := make(map[string]struct{})
+	for _, infos := range gp.repos {
+		for _, info := range infos {
+			urls[info.Repo] = struct{}{}
+		}
 	}
-	gp.pkgs = ctx.Packages
+	for _, pkg := range cache.Packages {
+		if _, ok := urls[pkg.Repo]; !ok {
+			return false, nil
+		}
+	}
+	gp.pkgs = cache.Packages
 	gp.init = true
-	return err
+	return true, err
 }
 
 func (gp *GetPack) savePkgs(fname string) error {
-	ctx := struct {
-		Packages lbctx.Packages
-	}{
+	cache := pkgsCache{
+		User:     gp.User,
+		Protocol: gp.Protocol,
 		Packages: gp.pkgs,
 	}
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return err
+	}
 	f, err := os.Create(fname)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	return toml.NewEncoder(f).Encode(&ctx)
+	err = toml.NewEncoder(f).Encode(&cache)
+	if err != nil {
+		return err
+	}
+	return f.Close()
 }
@@ -16,9 +16,13 @@ func init() {
 		UsageLine: "lbx",
 		Short:     "tools for development.",
 		Subcommands: []*commander.Command{
+			lbx_make_cmd_deps(),
+			lbx_make_cmd_env(),
 			lbx_make_cmd_init(),
 			lbx_make_cmd_pkg(),
 			lbx_make_cmd_run(),
+			lbx_make_cmd_shell(),
+			lbx_make_cmd_status(),
 			lbx_make_cmd_version(),
 			lbx_make_cmd_which(),
 		},
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -48,3 +50,609 @@ func TestInit(t *testing.T) {
 	_ = os.Chdir(pwd)
 	_ = os.RemoveAll(testinit)
 }
+
+func TestWhich(t *testing.T) {
+
+	for _, k := range []string{"CMAKE_PREFIX_PATH", "CMTPROJECTPATH", "LHCBPROJECTPATH", "User_release_area"} {
+		os.Setenv(k, "")
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	projdir := filepath.Join(pwd, "testdata/projects")
+	err = os.Setenv("LHCBPROJECTPATH", projdir)
+	if err != nil {
+		t.Fatalf("error setting LHCBPROJECTPATH: %v\n", err)
+	}
+
+	for _, table := range []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"gaudi", "GaudiKernel", "HEAD"},
+			want: filepath.Join(projdir, "GAUDI", "GAUDI_HEAD", "GaudiKernel"),
+		},
+		{
+			args: []string{"-d", "Gaudi", "gaudikernel", "HEAD"},
+			want: filepath.Join(projdir, "GAUDI", "GAUDI_HEAD", "GaudiKernel", "cmt"),
+		},
+	} {
+		args := append([]string{"which", "-lvl=-2"}, table.args...)
+		out, err := exec.Command("lbx", args...).Output()
+		if err != nil {
+			t.Fatalf("error running lbx-which %v: %v\n", table.args, err)
+		}
+		if got := strings.TrimSpace(string(out)); got != table.want {
+			t.Fatalf("lbx-which %v: expected %q. got=%q", table.args, table.want, got)
+		}
+	}
+
+	err = exec.Command("lbx", "which", "-lvl=-2", "gaudi", "NoSuchPackage", "HEAD").Run()
+	if err == nil {
+		t.Fatalf("expected lbx-which to fail on a missing package")
+	}
+
+	// the error of a missing project tells where it was looked for.
+	out, err := exec.Command("lbx", "which", "NoSuchProject", "HEAD").CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected lbx-which to fail on a missing project")
+	}
+	want := "not found in [" + projdir + "]"
+	if !strings.Contains(string(out), want) {
+		t.Fatalf("expected the error to contain %q. got:\n%s", want, string(out))
+	}
+}
+
+func TestEnv(t *testing.T) {
+
+	for _, k := range []string{"CMAKE_PREFIX_PATH", "CMTPROJECTPATH", "LHCBPROJECTPATH"} {
+		os.Setenv(k, "")
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	err = os.Setenv("LHCBPROJECTPATH", filepath.Join(pwd, "testdata/projects"))
+	if err != nil {
+		t.Fatalf("error setting LHCBPROJECTPATH: %v\n", err)
+	}
+
+	out, err := exec.Command(
+		"lbx", "env", "-lvl=-2", "-shell=sh", "-diff",
+		"-c=x86_64-slc6-gcc48-opt", "gaudi", "HEAD",
+	).Output()
+	if err != nil {
+		t.Fatalf("error running lbx-env: %v\n", err)
+	}
+
+	out, err = exec.Command("sh", "-c", string(out)+`
+printf "%s\n" "$GAUDI_MOTD"
+`).Output()
+	if err != nil {
+		t.Fatalf("error evaluating lbx-env output: %v\n", err)
+	}
+
+	if got, want := string(out), "Gaudi & friends\n"; got != want {
+		t.Fatalf("expected %q. got=%q", want, got)
+	}
+}
+
+func TestEnvExplain(t *testing.T) {
+
+	for _, k := range []string{"CMAKE_PREFIX_PATH", "CMTPROJECTPATH", "LHCBPROJECTPATH"} {
+		os.Setenv(k, "")
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	err = os.Setenv("LHCBPROJECTPATH", filepath.Join(pwd, "testdata/projects"))
+	if err != nil {
+		t.Fatalf("error setting LHCBPROJECTPATH: %v\n", err)
+	}
+
+	out, err := exec.Command(
+		"lbx", "env", "-lvl=-2", "-explain=GAUDI_MOTD",
+		"-c=x86_64-slc6-gcc48-opt", "gaudi", "HEAD",
+	).Output()
+	if err != nil {
+		t.Fatalf("error running lbx-env: %v\n", err)
+	}
+
+	for _, want := range []string{
+		"GaudiEnvironment.xml:6\n",
+		`set GAUDI_MOTD="Gaudi & friends"`,
+		`=> "Gaudi & friends"`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in the output. got:\n%s", want, string(out))
+		}
+	}
+}
+
+func TestEnvUndo(t *testing.T) {
+
+	for _, k := range []string{"CMAKE_PREFIX_PATH", "CMTPROJECTPATH", "LHCBPROJECTPATH"} {
+		os.Setenv(k, "")
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	err = os.Setenv("LHCBPROJECTPATH", filepath.Join(pwd, "testdata/projects"))
+	if err != nil {
+		t.Fatalf("error setting LHCBPROJECTPATH: %v\n", err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "lbx-env-undo-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	cmd := exec.Command("sh", "-c", `
+export GAUDI_MOTD="hello"
+before="$PATH"
+eval "$(lbx env -lvl=-2 -shell=sh -c=x86_64-slc6-gcc48-opt gaudi HEAD)"
+printf "%s\n" "$GAUDI_MOTD"
+test "$PATH" != "$before" || echo "PATH not modified"
+test -f "$LBX_ENV_UNDO" || echo "no undo file: $LBX_ENV_UNDO"
+undo="$LBX_ENV_UNDO"
+eval "$(lbx env -lvl=-2 -shell=sh -undo)"
+printf "%s\n" "$GAUDI_MOTD" "${GAUDIROOT-unset}" "${LBX_ENV_UNDO-unset}"
+test "$PATH" = "$before" || echo "PATH not restored: $PATH"
+eval "$(lbx env -lvl=-2 -shell=sh -c=x86_64-slc6-gcc48-opt gaudi HEAD)"
+test "$LBX_ENV_UNDO" = "$undo" || echo "undo file not reused: $LBX_ENV_UNDO"
+eval "$(lbx env -lvl=-2 -shell=sh -undo)"
+lbx env -lvl=-2 -shell=json -c=x86_64-slc6-gcc48-opt gaudi HEAD | grep LBX_ENV_UNDO
+lbx env -lvl=-2 -shell=sh -diff -c=x86_64-slc6-gcc48-opt gaudi HEAD | grep LBX_ENV_UNDO
+ls "$HOME/.lbx/env-undo" | wc -l | tr -d ' '
+`)
+	// the undo files are written under $HOME/.lbx.
+	cmd.Env = append(os.Environ(), "HOME="+tmpdir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("error running lbx-env -undo: %v\n%s", err, string(out))
+	}
+
+	if got, want := string(out), "Gaudi & friends\nhello\nunset\nunset\n1\n"; got != want {
+		t.Fatalf("expected %q. got=%q", want, got)
+	}
+}
+
+func TestShell(t *testing.T) {
+
+	for _, k := range []string{"CMAKE_PREFIX_PATH", "CMTPROJECTPATH", "LHCBPROJECTPATH", "LBX_SHELL", "LBX_SHELL_LEVEL"} {
+		os.Setenv(k, "")
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	err = os.Setenv("LHCBPROJECTPATH", filepath.Join(pwd, "testdata/projects"))
+	if err != nil {
+		t.Fatalf("error setting LHCBPROJECTPATH: %v\n", err)
+	}
+
+	args := []string{
+		"shell", "-lvl=-2", "-shell=sh",
+		"-c=x86_64-slc6-gcc48-opt", "gaudi", "HEAD",
+	}
+
+	cmd := exec.Command("lbx", args...)
+	cmd.Stdin = strings.NewReader(`echo "$LBX_SHELL|$LBX_SHELL_LEVEL|$GAUDI_MOTD"` + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("error running lbx-shell: %v\n", err)
+	}
+
+	if got, want := string(out), "Gaudi HEAD|1|Gaudi & friends\n"; got != want {
+		t.Fatalf("expected %q. got=%q", want, got)
+	}
+
+	// nested shells are refused by default
+	cmd = exec.Command("lbx", args...)
+	cmd.Env = append(os.Environ(), "LBX_SHELL=Gaudi HEAD", "LBX_SHELL_LEVEL=1")
+	cmd.Stdin = strings.NewReader("exit 0\n")
+	err = cmd.Run()
+	if err == nil {
+		t.Fatalf("expected nested lbx-shell to fail")
+	}
+
+	cmd = exec.Command("lbx", append([]string{args[0], "-nested"}, args[1:]...)...)
+	cmd.Env = append(os.Environ(), "LBX_SHELL=Gaudi HEAD", "LBX_SHELL_LEVEL=1")
+	cmd.Stdin = strings.NewReader(`echo "$LBX_SHELL_LEVEL"` + "\n")
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("error running nested lbx-shell: %v\n", err)
+	}
+	if got, want := string(out), "2\n"; got != want {
+		t.Fatalf("expected %q. got=%q", want, got)
+	}
+}
+
+func TestPkgCreate(t *testing.T) {
+
+	const testpkg = "testdata/test-pkg-create"
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	_ = os.RemoveAll(testpkg)
+
+	err = os.MkdirAll(testpkg, 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(testpkg)
+
+	err = os.Chdir(testpkg)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.Chdir(pwd)
+
+	cmd := exec.Command("lbx", "pkg", "create", "-lvl=-2", "-alg=MyAlg", "-tool=MyTool", "Hat/MyPackage")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("error running lbx-pkg-create: %v\n", err)
+	}
+
+	for _, fname := range []string{
+		"CMakeLists.txt",
+		"cmt/requirements",
+		"doc/release.notes",
+		"options/MyPackage.py",
+		"python/MyPackage/__init__.py",
+		"src/MyAlg.h",
+		"src/MyAlg.cpp",
+		"src/MyTool.h",
+		"src/MyTool.cpp",
+	} {
+		if !path_exists(filepath.Join("Hat/MyPackage", fname)) {
+			t.Fatalf("expected file [%s] to be created", fname)
+		}
+	}
+
+	cmake, err := ioutil.ReadFile("Hat/MyPackage/CMakeLists.txt")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, want := range []string{"gaudi_subdir(MyPackage v1r0)", "gaudi_add_module(MyPackage"} {
+		if !strings.Contains(string(cmake), want) {
+			t.Fatalf("expected CMakeLists.txt to contain %q:\n%s", want, string(cmake))
+		}
+	}
+
+	// existing packages are not overwritten
+	err = exec.Command("lbx", "pkg", "create", "-lvl=-2", "Hat/MyPackage").Run()
+	if err == nil {
+		t.Fatalf("expected lbx-pkg-create to fail on an existing package")
+	}
+
+	// invalid names are refused before anything is written
+	for _, args := range [][]string{
+		{"../Outside"},
+		{"/tmp/Hat/Abs"},
+		{"Hat/My-Package"},
+		{"-alg=My Alg", "Other/Pkg"},
+		{"-tool=1Tool", "Other/Pkg"},
+	} {
+		err = exec.Command("lbx", append([]string{"pkg", "create", "-lvl=-2"}, args...)...).Run()
+		if err == nil {
+			t.Fatalf("expected lbx-pkg-create %v to fail", args)
+		}
+	}
+	for _, dir := range []string{"../Outside", "/tmp/Hat/Abs", "Hat/My-Package", "Other"} {
+		if path_exists(dir) {
+			t.Fatalf("expected [%s] not to be created", dir)
+		}
+	}
+
+	// a failure part-way through leaves nothing behind
+	home, err := ioutil.TempDir("", "lbx-pkg-create-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(home)
+	tmpl := filepath.Join(home, ".lbx", "templates", "pkg", "Tool.cpp")
+	err = os.MkdirAll(filepath.Dir(tmpl), 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	err = ioutil.WriteFile(tmpl, []byte("{{.Broken"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	cmd = exec.Command("lbx", "pkg", "create", "-lvl=-2", "-tool=MyTool", "Other/MyPackage")
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	err = cmd.Run()
+	if err == nil {
+		t.Fatalf("expected lbx-pkg-create to fail on a broken template")
+	}
+	if path_exists("Other") {
+		t.Fatalf("expected the partial package to be removed")
+	}
+}
+
+func TestPkgCoURI(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-pkg-co-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	// a local git repository and a local (unversioned) package
+	for _, dir := range []string{"Repo/Hat/A", "src/Hat/B"} {
+		err = os.MkdirAll(filepath.Join(top, dir), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(top, dir, "CMakeLists.txt"), []byte(dir+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	repo := filepath.Join(top, "Repo")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=lbx", "-c", "user.email=lbx@example.com", "commit", "-q", "-m", "initial import"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, string(out))
+		}
+	}
+
+	work := filepath.Join(top, "work")
+	err = os.MkdirAll(work, 0755)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for _, table := range []struct {
+		args []string
+		work string
+		want string
+	}{
+		{[]string{"file://" + repo}, work, "Repo/Hat/A/CMakeLists.txt"},
+		{[]string{"../src/Hat/B"}, work, "B/CMakeLists.txt"},
+		// a branch name is given as <pkg>@<version>.
+		{[]string{"file://" + repo + "@master"}, filepath.Join(top, "work-branch"), "Repo/Hat/A/CMakeLists.txt"},
+	} {
+		err = os.MkdirAll(table.work, 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		cmd := exec.Command("lbx", append([]string{"pkg", "co"}, table.args...)...)
+		cmd.Dir = table.work
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("error running lbx-pkg-co %v: %v\n%s", table.args, err, string(out))
+		}
+		if !path_exists(filepath.Join(table.work, table.want)) {
+			t.Fatalf("expected [%s] to be checked out", table.want)
+		}
+	}
+}
+
+func TestPkgCoMany(t *testing.T) {
+	top, err := ioutil.TempDir("", "lbx-pkg-co-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	for _, dir := range []string{"src/Hat/A", "src/Hat/B", "src/Hat/C", "work"} {
+		err = os.MkdirAll(filepath.Join(top, dir), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	for _, pkg := range []string{"A", "B", "C"} {
+		err = ioutil.WriteFile(filepath.Join(top, "src", "Hat", pkg, "CMakeLists.txt"), []byte(pkg+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	work := filepath.Join(top, "work")
+	list := filepath.Join(top, "packages.txt")
+	err = ioutil.WriteFile(list, []byte("# packages\n../src/Hat/A\n\n../src/Hat/Missing head\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	cmd := exec.Command("lbx", "pkg", "co", "-j=2", "-f="+list, "../src/Hat/B", "../src/Hat/C")
+	cmd.Dir = work
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected lbx-pkg-co to fail on a missing package:\n%s", string(out))
+	}
+	if !strings.Contains(string(out), "1/4 package(s) could not be checked out") {
+		t.Fatalf("expected an error summary:\n%s", string(out))
+	}
+	for _, pkg := range []string{"A", "B", "C"} {
+		if !path_exists(filepath.Join(work, pkg, "CMakeLists.txt")) {
+			t.Fatalf("expected [%s] to be checked out:\n%s", pkg, string(out))
+		}
+	}
+}
+
+func TestPkgLs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-pkg-ls-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	// a local git repository as the only repository of the database.
+	repo := filepath.Join(top, "Repo")
+	home := filepath.Join(top, "home")
+	for _, dir := range []string{"Repo/Hat/A", "Repo/Hat/B", "home/.lbx", "out", "work/.lbx"} {
+		err = os.MkdirAll(filepath.Join(top, dir), 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(repo, "Hat", "A", "CMakeLists.txt"), []byte("A\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=lbx", "-c", "user.email=lbx@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, string(out))
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial import")
+
+	db := `
+[[repository]]
+name = "local"
+vcs  = "git"
+urls = ["file://` + repo + `"]
+`
+	for _, name := range []string{"gaudi", "lbsvn", "dirac", "lhcbint"} {
+		db += "\n[[repository]]\nname = \"" + name + "\"\nvcs = \"svn\"\ndisabled = true\n"
+	}
+	err = ioutil.WriteFile(filepath.Join(home, ".lbx", "repositories.toml"), []byte(db), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	ls := func(dir string, args ...string) string {
+		cmd := exec.Command("lbx", append([]string{"pkg", "ls"}, args...)...)
+		cmd.Dir = filepath.Join(top, dir)
+		cmd.Env = append(os.Environ(), "HOME="+home)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("error running lbx-pkg-ls %v: %v\n%s", args, err, string(out))
+		}
+		return string(out)
+	}
+
+	// outside of a workarea, the cache is kept under ~/.lbx
+	if out := ls("out"); !strings.Contains(out, "Hat/A") || strings.Contains(out, "Hat/B") {
+		t.Fatalf("unexpected packages:\n%s", out)
+	}
+	if !path_exists(filepath.Join(home, ".lbx", "packages-db.toml")) {
+		t.Fatalf("expected the packages cache under ~/.lbx")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(repo, "Hat", "B", "CMakeLists.txt"), []byte("B\n"), 0644)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "add Hat/B")
+
+	// the cache is used for the same user and protocol...
+	if out := ls("out"); strings.Contains(out, "Hat/B") {
+		t.Fatalf("expected the cached packages:\n%s", out)
+	}
+	// ...but not for another user...
+	if out := ls("out", "-user=someone"); !strings.Contains(out, "Hat/B") {
+		t.Fatalf("expected the repositories to be queried again:\n%s", out)
+	}
+	// ...and is refreshed on demand.
+	if out := ls("out", "-refresh", "Hat/B"); !strings.Contains(out, "Hat/B") || strings.Contains(out, "Hat/A") {
+		t.Fatalf("unexpected packages:\n%s", out)
+	}
+
+	// in a workarea, the cache is kept in its .lbx directory.
+	out := ls("work", "-o=json", "-project=Repo")
+	if !strings.Contains(out, `"name": "Hat/B"`) || !strings.Contains(out, `"repository": "file://`+repo+`"`) {
+		t.Fatalf("unexpected JSON output:\n%s", out)
+	}
+	if !path_exists(filepath.Join(top, "work", ".lbx", "packages-db.toml")) {
+		t.Fatalf("expected the packages cache in the workarea")
+	}
+}
+
+func TestDeps(t *testing.T) {
+	const platform = "x86_64-slc6-gcc48-opt"
+
+	for _, k := range []string{"CMAKE_PREFIX_PATH", "CMTPROJECTPATH", "LHCBPROJECTPATH"} {
+		os.Setenv(k, "")
+	}
+
+	top, err := ioutil.TempDir("", "lbx-deps-")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(top)
+
+	err = os.Setenv("LHCBPROJECTPATH", top)
+	if err != nil {
+		t.Fatalf("error setting LHCBPROJECTPATH: %v\n", err)
+	}
+
+	for _, proj := range []struct {
+		name, vers string
+		uses       string
+	}{
+		{"Gaudi", "v25r1", ""},
+		{"Gaudi", "v25r2", ""},
+		{"LHCb", "v36r1", `<project name="Gaudi" version="v25r2" />`},
+		{"Rec", "v16r1", `<project name="Gaudi" version="v25r1" />`},
+		{"Lbcom", "v15r1", `<project name="LHCb" version="v36r1" />`},
+		{"DaVinci", "v34r1", `<project name="Lbcom" version="v15r1" />`},
+		{"Brunel", "v46r1", `<project name="LHCb" version="v36r1" /><project name="Rec" version="v16r1" />`},
+	} {
+		dir := filepath.Join(top, proj.name+"_"+proj.vers, "InstallArea", platform)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		manifest := `<manifest><project name="` + proj.name + `" version="` + proj.vers + `" />` +
+			`<used_projects>` + proj.uses + `</used_projects></manifest>`
+		err = ioutil.WriteFile(filepath.Join(dir, "manifest.xml"), []byte(manifest), 0644)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	out, err := exec.Command("lbx", "deps", "-c="+platform, "-o=list", "DaVinci", "v34r1").CombinedOutput()
+	if err != nil {
+		t.Fatalf("error running lbx-deps: %v\n%s", err, string(out))
+	}
+
+	// conflicting versions of Gaudi
+	out, err = exec.Command("lbx", "deps", "-c="+platform, "-o=list", "Brunel", "v46r1").CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected lbx-deps to fail on a version conflict:\n%s", string(out))
+	}
+	if !strings.Contains(string(out), "version conflict") {
+		t.Fatalf("expected the version conflict to be reported:\n%s", string(out))
+	}
+}
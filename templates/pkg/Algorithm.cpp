@@ -0,0 +1,61 @@
+// Include files
+
+// local
+#include "{{.Name}}.h"
+
+//-----------------------------------------------------------------------------
+// Implementation file for class : {{.Name}}
+//
+// {{.Date}} : {{.User}}
+//-----------------------------------------------------------------------------
+
+// Declaration of the Algorithm Factory
+DECLARE_ALGORITHM_FACTORY( {{.Name}} )
+
+//=============================================================================
+// Standard constructor, initializes variables
+//=============================================================================
+{{.Name}}::{{.Name}}( const std::string& name,
+{{.Indent}}  ISvcLocator* pSvcLocator)
+  : GaudiAlgorithm ( name , pSvcLocator )
+{
+}
+
+//=============================================================================
+// Destructor
+//=============================================================================
+{{.Name}}::~{{.Name}}() {}
+
+//=============================================================================
+// Initialization
+//=============================================================================
+StatusCode {{.Name}}::initialize() {
+  StatusCode sc = GaudiAlgorithm::initialize(); // must be executed first
+  if ( sc.isFailure() ) return sc;  // error printed already by GaudiAlgorithm
+
+  if ( msgLevel(MSG::DEBUG) ) debug() << "==> Initialize" << endmsg;
+
+  return StatusCode::SUCCESS;
+}
+
+//=============================================================================
+// Main execution
+//=============================================================================
+StatusCode {{.Name}}::execute() {
+
+  if ( msgLevel(MSG::DEBUG) ) debug() << "==> Execute" << endmsg;
+
+  return StatusCode::SUCCESS;
+}
+
+//=============================================================================
+//  Finalize
+//=============================================================================
+StatusCode {{.Name}}::finalize() {
+
+  if ( msgLevel(MSG::DEBUG) ) debug() << "==> Finalize" << endmsg;
+
+  return GaudiAlgorithm::finalize();  // must be called after all other actions
+}
+
+//=============================================================================
@@ -0,0 +1,24 @@
+#ifndef {{.Guard}}
+#define {{.Guard}} 1
+
+// Include files
+// from Gaudi
+#include "GaudiAlg/GaudiAlgorithm.h"
+
+/** @class {{.Name}} {{.Name}}.h
+ *
+ *  @author {{.User}}
+ *  @date   {{.Date}}
+ */
+class {{.Name}} : public GaudiAlgorithm {
+public:
+  /// Standard constructor
+  {{.Name}}( const std::string& name, ISvcLocator* pSvcLocator );
+
+  virtual ~{{.Name}}( ); ///< Destructor
+
+  virtual StatusCode initialize();    ///< Algorithm initialization
+  virtual StatusCode execute   ();    ///< Algorithm execution
+  virtual StatusCode finalize  ();    ///< Algorithm finalization
+};
+#endif // {{.Guard}}
@@ -0,0 +1,52 @@
+// Include files
+
+// local
+#include "{{.Name}}.h"
+
+//-----------------------------------------------------------------------------
+// Implementation file for class : {{.Name}}
+//
+// {{.Date}} : {{.User}}
+//-----------------------------------------------------------------------------
+
+// Declaration of the Tool Factory
+DECLARE_TOOL_FACTORY( {{.Name}} )
+
+//=============================================================================
+// Standard constructor, initializes variables
+//=============================================================================
+{{.Name}}::{{.Name}}( const std::string& type,
+{{.Indent}}  const std::string& name,
+{{.Indent}}  const IInterface* parent )
+  : GaudiTool ( type, name , parent )
+{
+}
+
+//=============================================================================
+// Destructor
+//=============================================================================
+{{.Name}}::~{{.Name}}() {}
+
+//=============================================================================
+// Initialization
+//=============================================================================
+StatusCode {{.Name}}::initialize() {
+  StatusCode sc = GaudiTool::initialize(); // must be executed first
+  if ( sc.isFailure() ) return sc;  // error printed already by GaudiTool
+
+  if ( msgLevel(MSG::DEBUG) ) debug() << "==> Initialize" << endmsg;
+
+  return StatusCode::SUCCESS;
+}
+
+//=============================================================================
+//  Finalize
+//=============================================================================
+StatusCode {{.Name}}::finalize() {
+
+  if ( msgLevel(MSG::DEBUG) ) debug() << "==> Finalize" << endmsg;
+
+  return GaudiTool::finalize();  // must be called after all other actions
+}
+
+//=============================================================================
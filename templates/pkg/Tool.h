@@ -0,0 +1,25 @@
+#ifndef {{.Guard}}
+#define {{.Guard}} 1
+
+// Include files
+// from Gaudi
+#include "GaudiAlg/GaudiTool.h"
+
+/** @class {{.Name}} {{.Name}}.h
+ *
+ *  @author {{.User}}
+ *  @date   {{.Date}}
+ */
+class {{.Name}} : public GaudiTool {
+public:
+  /// Standard constructor
+  {{.Name}}( const std::string& type,
+             const std::string& name,
+             const IInterface* parent );
+
+  virtual ~{{.Name}}( ); ///< Destructor
+
+  virtual StatusCode initialize();    ///< Tool initialization
+  virtual StatusCode finalize  ();    ///< Tool finalization
+};
+#endif // {{.Guard}}
@@ -0,0 +1 @@
+## python modules of package {{.FullName}}
@@ -0,0 +1,6 @@
+## job options of package {{.FullName}}
+from Gaudi.Configuration import *
+{{range .Algs}}
+from Configurables import {{.}}
+ApplicationMgr().TopAlg += [ {{.}}() ]
+{{end}}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/gonuts/commander"
+	"github.com/gonuts/gas"
 	"github.com/gonuts/logger"
 )
 
@@ -29,6 +35,22 @@ func path_exists(name string) bool {
 	return false
 }
 
+// lbx_template_file returns the path to the template file name.
+// Templates from ~/.lbx/templates take precedence over the ones shipped with lbx.
+func lbx_template_file(name string) (string, error) {
+	if home := os.Getenv("HOME"); home != "" {
+		fname := filepath.Join(home, ".lbx", "templates", name)
+		if path_exists(fname) {
+			return fname, nil
+		}
+	}
+	dir, err := gas.Abs("github.com/lhcb-org/lbx/templates")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
 func handle_err(err error) {
 	if err != nil {
 		if g_ctx != nil {
@@ -52,6 +74,51 @@ func add_output_level(cmd *commander.Command) {
 	cmd.Flag.Int("lvl", logger.INFO, "message level to print")
 }
 
+func add_runtime_projects(cmd *commander.Command) {
+	cmd.Flag.Bool("use-grid", false, "enable auto selection of LHCbGrid project")
+	cmd.Flag.String("runtime-projects", "", "comma-separated list of runtime projects to add to the environment (e.g.: \"Foo:v1r2,Bar,Baz:v42\"")
+	cmd.Flag.String("overriding-projects", "", "comma-separated list of projects to override packages (e.g: \"Foo:v1r2,Bar,Baz:v42\")")
+	cmd.Flag.Bool("strict-env", false, "fail on unknown elements and attributes in the environment XML files")
+}
+
+func add_repositories(cmd *commander.Command) {
+	cmd.Flag.String("user", "", "user name for the repositories (see ~/.lbx/repositories.toml)")
+	cmd.Flag.String("protocol", "", "only use the repositories with this protocol (e.g. ssh, http)")
+}
+
+func add_timeout(cmd *commander.Command) {
+	cmd.Flag.Duration("timeout", 0, "give up the version control commands after this duration (e.g. 10m; 0 means no timeout)")
+}
+
+// lbx_context returns a context canceled on Ctrl-C (or SIGTERM), and after
+// the -timeout duration of cmd if it is not zero.
+// A second Ctrl-C terminates lbx right away.
+func lbx_context(cmd *commander.Command) (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	switch timeout := cmd.Flag.Lookup("timeout").Value.Get().(time.Duration); {
+	case timeout > 0:
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	default:
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigch)
+		select {
+		case sig := <-sigch:
+			g_ctx.Warnf("%v: stopping and cleaning up (press Ctrl-C again to exit right away)...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 func add_platform(cmd *commander.Command) {
 	var plat string
 	for _, k := range []string{"BINARY_TAG", "CMTCONFIG"} {